@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/bonaysoft/notion-md-gen/generator"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// dumpCmd represents the dump command
+var dumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "print the blocks of the --page as JSON, e.g. for new test cases",
+	Run: func(cmd *cobra.Command, args []string) {
+		var config generator.Config
+		if err := viper.Unmarshal(&config); err != nil {
+			log.Fatal(err)
+		}
+		pageID, _ := cmd.Flags().GetString("page")
+		if pageID == "" {
+			log.Fatal("dump requires --page <id>")
+		}
+		if err := generator.DumpPage(config, pageID, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dumpCmd)
+}
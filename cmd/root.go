@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bonaysoft/notion-md-gen/generator"
@@ -15,6 +18,10 @@ import (
 
 var cfgFile string
 
+// configDir is the directory of a config file discovered in a parent of the
+// working directory, its relative paths are resolved against it
+var configDir string
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "notion-md-gen",
@@ -42,13 +49,7 @@ var rootCmd = &cobra.Command{
 		var sinceTime *time.Time
 		sinceStr, _ := cmd.Flags().GetString("since")
 		if sinceStr != "" {
-			layoutLong := "20060102-15.04.05"
-			layoutShort := "20060102"
-			parsedTime, err := time.Parse(layoutLong, sinceStr)
-			if err != nil {
-				parsedTime, err = time.Parse(layoutShort, sinceStr)
-			}
-
+			parsedTime, err := parseSince(sinceStr, time.Now())
 			if err != nil {
 				log.Printf("Error parsing --since flag value '%s': %v. Ignoring flag.", sinceStr, err)
 			} else {
@@ -60,10 +61,53 @@ var rootCmd = &cobra.Command{
 		// get dry-run flag value
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		incremental, _ := cmd.Flags().GetBool("incremental")
-		cacheFile, _ := cmd.Flags().GetString("cache-file")
+		sinceCache, _ := cmd.Flags().GetBool("since-cache")
+		prune, _ := cmd.Flags().GetBool("prune")
+		progress, _ := cmd.Flags().GetBool("progress")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+		logFormat, _ := cmd.Flags().GetString("log-format")
+		readingTime, _ := cmd.Flags().GetBool("reading-time")
+		noDownloadImages, _ := cmd.Flags().GetBool("no-download-images")
+		filters, _ := cmd.Flags().GetStringArray("filter")
+		noStatusUpdate, _ := cmd.Flags().GetBool("no-status-update")
 		config.Incremental = incremental
-		config.CacheFile = cacheFile
+		config.Prune = prune
+		config.Progress = progress
+		config.Verbose = verbose
+		config.LogFormat = logFormat
+		if readingTime {
+			config.ReadingTime = true
+		}
+		if sinceCache {
+			config.SinceCache = true
+		}
+		if noDownloadImages {
+			config.KeepRemoteImages = true
+		}
+		if noStatusUpdate {
+			config.NoStatusUpdate = true
+		}
+		config.Filters = append(config.Filters, filters...)
+		applyConfigPaths(cmd, &config)
+		applyOutputFlag(cmd, &config)
+		if cmd.Flags().Changed("limit") {
+			config.Limit, _ = cmd.Flags().GetInt("limit")
+		}
+
+		if stdout, _ := cmd.Flags().GetBool("stdout"); stdout {
+			pageID, _ := cmd.Flags().GetString("page")
+			if pageID == "" {
+				log.Fatal("--stdout requires --page <id>")
+			}
+			if err := generator.PreviewPage(config, pageID, os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
 
+		if err := config.Validate(); err != nil {
+			log.Fatal(err)
+		}
 		if err := generator.Run(config, args, sinceTime, dryRun); err != nil {
 			log.Println(err)
 		}
@@ -87,36 +131,164 @@ func init() {
 	rootCmd.PersistentFlags().Bool("parallelize", true, "enable parallel fetching of block trees")
 	// add flag to set parallelism level, with short version -j
 	rootCmd.PersistentFlags().IntP("parallelism", "j", 5, "number of concurrent block tree fetches (use 0 for serial mode)")
+	rootCmd.PersistentFlags().Bool("adaptive-parallelism", false, "start with one fetch and raise it up to --parallelism while notion doesn't answer 429")
+	// add flag to pace requests to the notion api
+	rootCmd.PersistentFlags().Float64("requests-per-second", 3, "maximum notion api requests per second across all workers (use 0 for no limit)")
 	// bind flags to viper
 	_ = viper.BindPFlag("parallelize", rootCmd.PersistentFlags().Lookup("parallelize"))
 	_ = viper.BindPFlag("parallelism", rootCmd.PersistentFlags().Lookup("parallelism"))
+	_ = viper.BindPFlag("adaptiveParallelism", rootCmd.PersistentFlags().Lookup("adaptive-parallelism"))
+	_ = viper.BindPFlag("requestsPerSecond", rootCmd.PersistentFlags().Lookup("requests-per-second"))
 
 	// add since flag
-	rootCmd.PersistentFlags().String("since", "", "retrieve only items modified since this date (YYYYMMDD or YYYYMMDD-HH.MM.SS)")
+	rootCmd.PersistentFlags().String("since", "", "retrieve only items modified since this date (YYYYMMDD, YYYYMMDD-HH.MM.SS or RFC3339) or duration ago (e.g. 7d or 48h)")
 	// add dry-run flag
 	rootCmd.PersistentFlags().Bool("dry-run", false, "list matching articles without downloading or changing status")
 	rootCmd.PersistentFlags().Bool("incremental", true, "skip pages that have not changed since the last run")
 	rootCmd.PersistentFlags().String("cache-file", ".notion-md-gen-cache.json", "cache file path used for incremental sync state")
+	rootCmd.PersistentFlags().Bool("since-cache", false, "retrieve only items modified since the last run recorded in the cache file (combines with --since)")
+	rootCmd.PersistentFlags().Bool("prune", false, "remove previously generated files of pages no longer in the database results")
+	rootCmd.PersistentFlags().Bool("progress", false, "show a progress bar instead of per-page log lines")
+	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "print detailed per-page log lines (overrides --progress)")
+	rootCmd.PersistentFlags().String("log-format", generator.LogFormatText, "log output format: text or json")
+	rootCmd.PersistentFlags().StringArray("filter", nil, "only process pages whose property has a value, as Property=Value (repeatable)")
+	rootCmd.PersistentFlags().StringP("output", "o", "", "directory for the generated files, overrides markdown.postSavePath")
+	rootCmd.PersistentFlags().String("zip", "", "write the generated files and images into this zip archive, overrides zipFile")
+	rootCmd.PersistentFlags().Int("limit", 0, "only process the first N pages after filtering (0 for no limit)")
+	rootCmd.PersistentFlags().String("page", "", "id of the page to print with --stdout or dump")
+	rootCmd.PersistentFlags().Bool("stdout", false, "print the markdown of the --page to stdout without writing files or changing its status")
+	rootCmd.PersistentFlags().Bool("reading-time", false, "add word_count and reading_time front matter fields")
+	rootCmd.PersistentFlags().Bool("no-download-images", false, "link images and covers at their notion urls instead of downloading them")
+	rootCmd.PersistentFlags().Bool("no-status-update", false, "generate the pages without changing their status in notion")
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	// init writes a new config into the working directory, whatever is above it
+	if executing(initCmd, os.Args[1:]) {
+		return
+	}
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
 	} else {
-		viper.AddConfigPath(".")
+		// also find the config of the site when running from one of its subdirectories
+		wd, err := os.Getwd()
+		if err != nil {
+			wd = "."
+		}
+		for _, dir := range configSearchPaths(wd) {
+			viper.AddConfigPath(dir)
+		}
 		viper.SetConfigName("notion-md-gen")
 	}
 
-	if err := godotenv.Load(); err == nil {
-		fmt.Println("Load .env file")
+	// keep stdout clean for the markdown printed by --stdout and the JSON of dump
+	out := os.Stdout
+	if stdout, _ := rootCmd.PersistentFlags().GetBool("stdout"); stdout || executing(dumpCmd, os.Args[1:]) {
+		out = os.Stderr
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
-
 	// If a config file is found, read it in.
+	envFile := ".env"
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(out, "Using config file:", viper.ConfigFileUsed())
+		// paths in a discovered config are relative to its directory, like the .env next to it
+		if cfgFile == "" {
+			configDir = filepath.Dir(viper.ConfigFileUsed())
+			envFile = filepath.Join(configDir, ".env")
+		}
+	}
+
+	if err := godotenv.Load(envFile); err == nil {
+		fmt.Fprintln(out, "Load .env file")
+	}
+
+	viper.AutomaticEnv() // read in environment variables that match
+}
+
+// executing reports whether the command line args run the command c
+func executing(c *cobra.Command, args []string) bool {
+	cmd, _, err := rootCmd.Find(args)
+	return err == nil && cmd == c
+}
+
+// applyConfigPaths expands the environment variables in the paths of the
+// config and resolves them against the directory of a discovered config file.
+// The cache file is kept next to the config unless --cache-file is given.
+func applyConfigPaths(cmd *cobra.Command, config *generator.Config) {
+	if config.CacheFile == "" {
+		config.CacheFile, _ = cmd.Flags().GetString("cache-file")
+	}
+	config.ExpandEnv()
+	if configDir != "" {
+		if wd, err := os.Getwd(); err == nil {
+			config.ResolvePaths(configDir, wd)
+		}
+	}
+	if cmd.Flags().Changed("cache-file") {
+		config.CacheFile, _ = cmd.Flags().GetString("cache-file")
+	}
+}
+
+// applyOutputFlag lets --output and --zip win over the postSavePath and
+// zipFile of the config file. Like all paths on the command line they are
+// relative to the working directory.
+func applyOutputFlag(cmd *cobra.Command, config *generator.Config) {
+	if output, _ := cmd.Flags().GetString("output"); output != "" {
+		config.PostSavePath = output
+	}
+	if zipFile, _ := cmd.Flags().GetString("zip"); zipFile != "" {
+		config.ZipFile = zipFile
+	}
+}
+
+// sinceLayouts are the date formats accepted by --since
+var sinceLayouts = []string{"20060102-15.04.05", "20060102", time.RFC3339}
+
+// parseSince parses the value of --since: a date in one of the sinceLayouts,
+// or a lookback from now such as 7d or 48h. Days are 24 hours, other units
+// are those of time.ParseDuration.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	for _, layout := range sinceLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	var lookback time.Duration
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is neither a date nor a duration like 7d or 48h", value)
+		}
+		lookback = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is neither a date nor a duration like 7d or 48h", value)
+		}
+		lookback = d
+	}
+	if lookback <= 0 {
+		return time.Time{}, fmt.Errorf("duration %q must be positive", value)
+	}
+	return now.Add(-lookback), nil
+}
+
+// configSearchPaths returns dir and its parents up to the root of the git
+// repository containing dir, nearest first. Outside of a git repository only
+// dir itself is searched.
+func configSearchPaths(dir string) []string {
+	paths := []string{dir}
+	for current := dir; ; {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return paths
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return paths[:1]
+		}
+		current = parent
+		paths = append(paths, current)
 	}
 }
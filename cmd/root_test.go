@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bonaysoft/notion-md-gen/generator"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigDiscoveredInParentDirectory(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, ".git"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "notion-md-gen.yaml"), []byte("notion:\n  databaseId: abc\n"), 0644))
+	child := filepath.Join(root, "content", "posts")
+	assert.NoError(t, os.MkdirAll(child, 0755))
+
+	assert.Equal(t, []string{child, filepath.Join(root, "content"), root}, configSearchPaths(child))
+
+	v := viper.New()
+	for _, dir := range configSearchPaths(child) {
+		v.AddConfigPath(dir)
+	}
+	v.SetConfigName("notion-md-gen")
+	assert.NoError(t, v.ReadInConfig())
+	assert.Equal(t, filepath.Join(root, "notion-md-gen.yaml"), v.ConfigFileUsed())
+	assert.Equal(t, "abc", v.GetString("notion.databaseId"))
+}
+
+func TestConfigSearchOutsideGitRepository(t *testing.T) {
+	dir := t.TempDir()
+	assert.Equal(t, []string{dir}, configSearchPaths(dir))
+}
+
+func TestOutputFlagOverridesConfig(t *testing.T) {
+	var config generator.Config
+	config.PostSavePath = "content/posts"
+
+	applyOutputFlag(rootCmd, &config)
+	assert.Equal(t, "content/posts", config.PostSavePath)
+
+	assert.NoError(t, rootCmd.ParseFlags([]string{"-o", "public/notion"}))
+	defer rootCmd.Flags().Set("output", "")
+	applyOutputFlag(rootCmd, &config)
+	assert.Equal(t, "public/notion", config.PostSavePath)
+}
+
+func TestConfigPathsRelativeToDiscoveredConfig(t *testing.T) {
+	root := t.TempDir()
+	child := filepath.Join(root, "content")
+	assert.NoError(t, os.Mkdir(child, 0755))
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(child))
+	defer os.Chdir(wd)
+	configDir = root
+	defer func() { configDir = "" }()
+
+	var config generator.Config
+	config.PostSavePath = "content/posts"
+	applyConfigPaths(rootCmd, &config)
+	assert.Equal(t, "posts", config.PostSavePath)
+	assert.Equal(t, filepath.Join("..", ".notion-md-gen-cache.json"), config.CacheFile)
+
+	// paths on the command line stay relative to the working directory
+	assert.NoError(t, rootCmd.ParseFlags([]string{"--cache-file", "cache.json", "--zip", "site.zip"}))
+	defer rootCmd.Flags().Set("cache-file", ".notion-md-gen-cache.json")
+	defer rootCmd.Flags().Set("zip", "")
+	applyConfigPaths(rootCmd, &config)
+	applyOutputFlag(rootCmd, &config)
+	assert.Equal(t, "cache.json", config.CacheFile)
+	assert.Equal(t, "site.zip", config.ZipFile)
+}
+
+func TestInitSkipsConfigDiscovery(t *testing.T) {
+	assert.True(t, executing(initCmd, []string{"init"}))
+	assert.False(t, executing(initCmd, []string{"--limit", "5"}))
+	assert.True(t, executing(dumpCmd, []string{"dump", "--page", "abc"}))
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	for value, want := range map[string]time.Time{
+		"20240301":                  time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"20240301-08.30.00":         time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC),
+		"2024-03-01T08:30:00+02:00": time.Date(2024, 3, 1, 6, 30, 0, 0, time.UTC),
+		"48h":                       time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC),
+		"7d":                        time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC),
+		"90m":                       time.Date(2024, 3, 10, 10, 30, 0, 0, time.UTC),
+	} {
+		got, err := parseSince(value, now)
+		if assert.NoError(t, err, value) {
+			assert.True(t, want.Equal(got), "%s: got %s", value, got)
+		}
+	}
+
+	for _, value := range []string{"yesterday", "xd", "-2h", "0d", "2024/03/01"} {
+		_, err := parseSince(value, now)
+		assert.Error(t, err, value)
+	}
+}
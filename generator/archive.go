@@ -0,0 +1,93 @@
+package generator
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runZip exports into a temporary directory and packs it into config.ZipFile.
+// The archive always contains every matching page, so the incremental cache
+// and pruning are not used.
+func runZip(config Config, filterArgs []string, since *time.Time, dryRun bool) error {
+	dir, err := os.MkdirTemp("", "notion-md-gen-")
+	if err != nil {
+		return fmt.Errorf("couldn't create staging folder: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Incremental = false
+	config.Prune = false
+	config.SinceCache = false
+	config.Markdown.PostSavePath = stagedPath(dir, config.Markdown.PostSavePath)
+	config.Markdown.ImageSavePath = stagedPath(dir, config.Markdown.ImageSavePath)
+	if config.Markdown.SingleFile != "" {
+		config.Markdown.SingleFile = stagedPath(dir, config.Markdown.SingleFile)
+	}
+	if err := run(config, filterArgs, since, dryRun); err != nil || dryRun {
+		return err
+	}
+	if err := writeZip(config.ZipFile, dir); err != nil {
+		return fmt.Errorf("failed writing zip file %q: %w", config.ZipFile, err)
+	}
+	return nil
+}
+
+// stagedPath places a configured path inside the staging dir. Leading "/" and
+// "../" are dropped, so the archive keeps the path below them.
+func stagedPath(dir, path string) string {
+	return filepath.Join(dir, filepath.Clean(string(filepath.Separator)+path))
+}
+
+// writeZip packs the files below dir into a zip archive at path, named by
+// their path relative to dir.
+func writeZip(path, dir string) error {
+	if parent := filepath.Dir(path); parent != "." && parent != "" {
+		if err := os.MkdirAll(parent, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	err = filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(w, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -10,10 +10,15 @@ import (
 type cacheEntry struct {
 	LastEdited string `json:"last_edited"`
 	OutputPath string `json:"output_path"`
+	ImagePath  string `json:"image_path,omitempty"`
+	// StatusPending is set while the Notion status of the generated page is changed
+	StatusPending bool `json:"status_pending,omitempty"`
 }
 
 type runCache struct {
 	Pages map[string]cacheEntry `json:"pages"`
+	// LastRun is the start of the last successful run with --since-cache
+	LastRun string `json:"last_run,omitempty"`
 }
 
 func defaultCache() runCache {
@@ -64,3 +69,20 @@ func saveCache(path string, cache runCache) error {
 func cacheTimestamp(t time.Time) string {
 	return t.UTC().Format(time.RFC3339Nano)
 }
+
+// sinceLastRun returns the cutoff of a --since-cache run: the earlier of since
+// and the last run in the cache, so neither skips pages the other would
+// process. Without a recorded run since is returned unchanged.
+func sinceLastRun(since *time.Time, cache runCache) *time.Time {
+	lastRun, err := time.Parse(time.RFC3339Nano, cache.LastRun)
+	if err != nil {
+		return since
+	}
+	// Notion rounds last_edited_time down to the minute, a page edited during
+	// the minute the last run started may show an earlier time
+	cutoff := lastRun.Truncate(time.Minute).Add(-time.Nanosecond)
+	if since != nil && since.Before(cutoff) {
+		return since
+	}
+	return &cutoff
+}
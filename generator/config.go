@@ -4,7 +4,13 @@ import (
 	"fmt"
 	"io/fs"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/bonaysoft/notion-md-gen/pkg/tomarkdown"
+	"github.com/dstotijn/go-notion"
 	"gopkg.in/yaml.v3"
 )
 
@@ -13,18 +19,151 @@ type Notion struct {
 	FilterProp     string   `yaml:"filterProp"`
 	FilterValue    []string `yaml:"filterValue"`
 	PublishedValue string   `yaml:"publishedValue"`
+	// NoStatusUpdate generates the pages without setting their status to PublishedValue
+	NoStatusUpdate bool `yaml:"noStatusUpdate,omitempty"`
+	// APIVersion overrides the Notion-Version header sent to the API (optional)
+	APIVersion string `yaml:"apiVersion,omitempty"`
+	// Sorts orders the queried pages, the first sort taking precedence (optional)
+	Sorts []Sort `yaml:"sorts,omitempty"`
+	// Headers are sent with every Notion API request, e.g. for an auth proxy (optional)
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+// Sort orders pages by a database property or by the created_time or
+// last_edited_time timestamp, in ascending (default) or descending direction.
+type Sort struct {
+	Property  string `yaml:"property,omitempty"`
+	Timestamp string `yaml:"timestamp,omitempty"`
+	Direction string `yaml:"direction,omitempty"`
 }
 
 type Markdown struct {
-	ShortcodeSyntax string `yaml:"shortcodeSyntax"` // hugo,hexo,vuepress
+	ShortcodeSyntax string `yaml:"shortcodeSyntax"` // hugo,hexo,vuepress,custom
 	PageNamePrefix  string `yaml:"pageNamePrefix"`
 	PostSavePath    string `yaml:"postSavePath"`
 	ImageSavePath   string `yaml:"imageSavePath"`
 	ImagePublicLink string `yaml:"imagePublicLink"`
 
 	// Optional:
+	// TitleProperty names the database column holding the page title, of any
+	// type with a text value, e.g. a number or a select; when empty a title
+	// column called "title" or "name" is looked up
+	TitleProperty string `yaml:"titleProperty,omitempty"`
+	// UntitledName prefixes the page ID in the file name of pages without a title
+	UntitledName string `yaml:"untitledName,omitempty"`
 	GroupByMonth bool   `yaml:"groupByMonth,omitempty"`
-	Template     string `yaml:"template,omitempty"`
+	// PathProperty names a property whose value is the directory of the page's file, e.g. Category (optional)
+	PathProperty string `yaml:"pathProperty,omitempty"`
+	// PreserveTitleFilename names files exactly after the page title instead of
+	// a lowercased, dashed version; only illegal characters are replaced
+	PreserveTitleFilename bool `yaml:"preserveTitleFilename,omitempty"`
+	// TransliterateFilenames names files of non-Latin titles in ASCII, e.g. "Привет мир" as privet-mir
+	TransliterateFilenames bool `yaml:"transliterateFilenames,omitempty"`
+	// Transliterate replaces the built-in transliteration of file names when set
+	Transliterate func(title string) string `yaml:"-"`
+	// OutputExtension of the generated files, e.g. .mdx (default .md)
+	OutputExtension string `yaml:"outputExtension,omitempty"`
+	Template        string `yaml:"template,omitempty"`
+	// WrapperTemplate wraps the body of every page below the front matter, e.g. with a license footer
+	WrapperTemplate string `yaml:"wrapperTemplate,omitempty"`
+	// TemplateDir overrides block templates with same-named files, e.g. paragraph.gohtml
+	TemplateDir string `yaml:"templateDir,omitempty"`
+	// ShortcodeTemplateDir holds the block templates of shortcodeSyntax: custom, e.g. callout.gohtml
+	ShortcodeTemplateDir string `yaml:"shortcodeTemplateDir,omitempty"`
+	// LastmodField names the front matter field holding the last edited time (default lastmod)
+	LastmodField string `yaml:"lastmodField,omitempty"`
+	LinkStyle    string `yaml:"linkStyle,omitempty"` // inline,reference
+	// InternalLinkStyle writes links between pages and to downloaded images as Obsidian wikilinks: markdown,wikilink
+	InternalLinkStyle string `yaml:"internalLinkStyle,omitempty"`
+	// PageIDField names a front matter field holding the Notion ID of the page, e.g. notion_id (optional)
+	PageIDField string `yaml:"pageIdField,omitempty"`
+	// CreatedByField and LastEditedByField name front matter fields holding the name of
+	// the user who created or last edited the page, e.g. author (optional)
+	CreatedByField    string `yaml:"createdByField,omitempty"`
+	LastEditedByField string `yaml:"lastEditedByField,omitempty"`
+	// FrontMatterDefaults are added to the front matter of every page that doesn't set them
+	FrontMatterDefaults map[string]interface{} `yaml:"frontMatterDefaults,omitempty"`
+	// DateRanges writes dates with an end as start_<name> and end_<name> fields
+	DateRanges bool `yaml:"dateRanges,omitempty"`
+	// FrontMatterMapping renames Notion properties in the front matter, e.g. PublishDate: date
+	FrontMatterMapping map[string]string `yaml:"frontMatterMapping,omitempty"`
+	// DisableFrontMatter writes no front matter, e.g. for READMEs or wikis
+	DisableFrontMatter bool `yaml:"disableFrontMatter,omitempty"`
+	// FrontMatterFormat of the generated files: yaml (default), toml or json
+	FrontMatterFormat string `yaml:"frontMatterFormat,omitempty"`
+	// EscapeMarkdown escapes Markdown characters in plain text (default true)
+	EscapeMarkdown *bool `yaml:"escapeMarkdown,omitempty"`
+	// IndentUnit indents one level of nested lists and blocks, e.g. "\t" (default four spaces)
+	IndentUnit string `yaml:"indentUnit,omitempty"`
+	// SingleFile writes every page into this one file instead of one file per page
+	SingleFile string `yaml:"singleFile,omitempty"`
+	// BookmarkTimeout is the number of seconds to wait for a bookmark's metadata
+	BookmarkTimeout int `yaml:"bookmarkTimeout,omitempty"`
+	// BookmarkCacheFile keeps the metadata of bookmarks between runs (optional)
+	BookmarkCacheFile string `yaml:"bookmarkCacheFile,omitempty"`
+	// ImageRetries is how often a failed image download is retried (default 3, -1 disables)
+	ImageRetries int `yaml:"imageRetries,omitempty"`
+	// ImageConcurrency is how many images all pages download at the same time (default 8, -1 disables the limit)
+	ImageConcurrency int `yaml:"imageConcurrency,omitempty"`
+	// KeepRemoteImages links images at their source instead of downloading them
+	KeepRemoteImages bool `yaml:"keepRemoteImages,omitempty"`
+	// ImageConvert transcodes downloaded PNG/JPEG images to jpeg, png, webp or a
+	// format registered with tomarkdown.RegisterImageEncoder
+	ImageConvert string `yaml:"imageConvert,omitempty"`
+	ImageQuality int    `yaml:"imageQuality,omitempty"`
+	// MaxImageWidth downscales wider images, keeping the aspect ratio
+	MaxImageWidth int `yaml:"maxImageWidth,omitempty"`
+	// ImageStore receives the downloaded images instead of imageSavePath, e.g. to upload them to a CDN
+	ImageStore tomarkdown.ImageStore `yaml:"-"`
+	// InlineImageMaxBytes embeds smaller images as data: URIs, e.g. for singleFile exports
+	InlineImageMaxBytes int `yaml:"inlineImageMaxBytes,omitempty"`
+	// LinkedDatabaseRows lists up to this many pages below embedded databases (0 writes the title only)
+	LinkedDatabaseRows int `yaml:"linkedDatabaseRows,omitempty"`
+	// MaxBlockDepth limits how many levels of nested blocks are fetched (default 16)
+	MaxBlockDepth int `yaml:"maxBlockDepth,omitempty"`
+	// CodeCaption writes code block captions as a line above the block or as fence title: line,title
+	CodeCaption string `yaml:"codeCaption,omitempty"`
+	// WideTableColumns wraps tables with more columns in WideTableWrapper (0 disables)
+	WideTableColumns int `yaml:"wideTableColumns,omitempty"`
+	// WideTableWrapper surrounds wide tables, %s marks the table (default <div class="table-wrapper">)
+	WideTableWrapper string `yaml:"wideTableWrapper,omitempty"`
+	// EquationNumbering numbers the equation blocks of every page with \tag{n}
+	EquationNumbering bool `yaml:"equationNumbering,omitempty"`
+	// ImageTitleSeparator splits image captions into alt text and image title, e.g. "|"
+	ImageTitleSeparator string `yaml:"imageTitleSeparator,omitempty"`
+	// PlainFallback renders callouts as blockquotes and bookmarks as links when shortcodeSyntax is empty
+	PlainFallback bool `yaml:"plainFallback,omitempty"`
+	// PlainBookmarkTitles fetches the titles of plain bookmark links, which otherwise show the URL
+	PlainBookmarkTitles bool `yaml:"plainBookmarkTitles,omitempty"`
+	// ToggleHeadingDetails folds the content of toggleable headings in <details>
+	ToggleHeadingDetails bool `yaml:"toggleHeadingDetails,omitempty"`
+	// ContinuousNumbering continues numbered lists split by empty paragraphs instead of restarting at 1
+	ContinuousNumbering bool `yaml:"continuousNumbering,omitempty"`
+	// SyncedBlockMarkers wraps synced content in <!-- synced-block: <id> --> comments
+	SyncedBlockMarkers bool `yaml:"syncedBlockMarkers,omitempty"`
+	// ReadingTime adds word_count and reading_time front matter fields
+	ReadingTime bool `yaml:"readingTime,omitempty"`
+	// WordsPerMinute is the reading speed for reading_time (default 200)
+	WordsPerMinute int `yaml:"wordsPerMinute,omitempty"`
+
+	// bookmarks is shared by all pages of a run, see Run
+	bookmarks *tomarkdown.BookmarkCache
+	// imageDownloads bounds the image downloads of all pages of a run, see Run
+	imageDownloads *tomarkdown.DownloadLimiter
+	// imageIndex saves images with identical content once for all pages of a run, see Run
+	imageIndex *tomarkdown.ImageIndex
+	// pages links the exported pages to each other, see Run
+	pages pageIndex
+	// columnWidths are recorded while fetching blocks, see Run
+	columnWidths *columnWidths
+	// blockColors are recorded while fetching blocks, see Run
+	blockColors *blockColors
+	// editors of the pages are recorded while querying them, see Run
+	editors *pageEditors
+	// imageTransport replaces the network transport of image downloads in tests, see Run
+	imageTransport http.RoundTripper
+	// logger reports the warnings of the pages of a run, see Run
+	logger *runLogger
 }
 
 type Config struct {
@@ -34,16 +173,191 @@ type Config struct {
 	Parallelize bool `yaml:"parallelize"`
 	// number of concurrent block tree fetches
 	Parallelism int `yaml:"parallelism"`
+	// start with one fetch and grow up to parallelism while Notion doesn't throttle, halving on 429s
+	AdaptiveParallelism bool `yaml:"adaptiveParallelism,omitempty"`
+	// maximum Notion API requests per second shared by all workers (0 disables)
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
 	// skip unchanged pages using a local cache file
 	Incremental bool `yaml:"incremental"`
 	// cache file path for incremental sync state
 	CacheFile string `yaml:"cacheFile"`
+	// only process pages edited since the last run recorded in the cache file
+	SinceCache bool `yaml:"sinceCache"`
+	// remove generated files of pages no longer returned by the database
+	Prune bool `yaml:"prune"`
+	// show a single progress bar instead of per-page log lines
+	Progress bool `yaml:"progress"`
+	// keep the detailed per-page log lines, even in progress mode
+	Verbose bool `yaml:"verbose"`
+	// log output format: text (default) or json
+	LogFormat string `yaml:"logFormat"`
+	// write a JSON manifest of the exported pages to this path (optional)
+	ManifestFile string `yaml:"manifestFile,omitempty"`
+	// write the generated files and images into this zip archive instead of the folders (optional)
+	ZipFile string `yaml:"zipFile,omitempty"`
+	// only process pages whose properties match every "Property=Value" filter
+	Filters []string `yaml:"filters,omitempty"`
+	// only process the first pages after filtering (0 processes all)
+	Limit int `yaml:"limit,omitempty"`
+	// also export archived pages, which are skipped by default
+	IncludeArchived bool `yaml:"includeArchived,omitempty"`
+
+	// Client is used for the Notion API instead of a client built from the
+	// NOTION_SECRET and the settings above, e.g. a mock or one talking to a
+	// fake server. Column widths, callout colors and page editors are not
+	// recorded with it, and status properties are neither read nor filtered
+	// or updated: go-notion only knows select properties, the status ones are
+	// translated on the HTTP transport of the built client.
+	Client NotionAPI `yaml:"-"`
+
+	// transport replaces the network transport of the Notion client in tests
+	transport http.RoundTripper
+	// workers bounds the pages processed at the same time, see Run
+	workers *workerLimiter
+}
+
+// ExpandEnv replaces ${VAR} and $VAR in the configured paths and headers with
+// the values of the environment variables, $$ stands for a literal $.
+func (c *Config) ExpandEnv() {
+	for _, path := range c.paths() {
+		*path = expandEnv(*path)
+	}
+	for name, value := range c.Headers {
+		c.Headers[name] = expandEnv(value)
+	}
+}
+
+// ResolvePaths makes the relative paths of a config file in dir relative to
+// the working directory wd instead, e.g. content/posts in ../notion-md-gen.yaml
+// becomes ../content/posts. Paths outside of wd's reach stay absolute.
+func (c *Config) ResolvePaths(dir, wd string) {
+	for _, path := range c.paths() {
+		if *path == "" || filepath.IsAbs(*path) {
+			continue
+		}
+		abs := filepath.Join(dir, *path)
+		if rel, err := filepath.Rel(wd, abs); err == nil {
+			*path = rel
+		} else {
+			*path = abs
+		}
+	}
+}
+
+// paths returns the configured file and directory paths
+func (c *Config) paths() []*string {
+	return []*string{
+		&c.PostSavePath,
+		&c.ImageSavePath,
+		&c.Template,
+		&c.WrapperTemplate,
+		&c.TemplateDir,
+		&c.ShortcodeTemplateDir,
+		&c.SingleFile,
+		&c.BookmarkCacheFile,
+		&c.CacheFile,
+		&c.ManifestFile,
+		&c.ZipFile,
+	}
+}
+
+func expandEnv(s string) string {
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		return os.Getenv(name)
+	})
+}
+
+// placeholderDatabaseID is written by DefaultConfigInit for the user to replace
+const placeholderDatabaseID = "YOUR-NOTION-DATABASE-ID"
+
+// Validate checks the config for missing or unknown values before anything
+// is fetched, reporting every problem at once.
+func (c Config) Validate() error {
+	var problems []string
+	switch c.DatabaseID {
+	case "":
+		problems = append(problems, "notion.databaseId is required")
+	case placeholderDatabaseID:
+		problems = append(problems, "notion.databaseId still has the placeholder value, set it to the id of your database")
+	}
+	for i, sort := range c.Sorts {
+		if (sort.Property == "") == (sort.Timestamp == "") {
+			problems = append(problems, fmt.Sprintf("notion.sorts[%d] needs either a property or a timestamp", i))
+		}
+		if sort.Timestamp != "" && sort.Timestamp != string(notion.SortTimeStampCreatedTime) && sort.Timestamp != string(notion.SortTimeStampLastEditedTime) {
+			problems = append(problems, fmt.Sprintf("notion.sorts[%d].timestamp %q is unknown, use %s or %s",
+				i, sort.Timestamp, notion.SortTimeStampCreatedTime, notion.SortTimeStampLastEditedTime))
+		}
+		if sort.Direction != "" && sort.Direction != string(notion.SortDirAsc) && sort.Direction != string(notion.SortDirDesc) {
+			problems = append(problems, fmt.Sprintf("notion.sorts[%d].direction %q is unknown, use %s or %s",
+				i, sort.Direction, notion.SortDirAsc, notion.SortDirDesc))
+		}
+	}
+	if c.PostSavePath == "" {
+		problems = append(problems, "markdown.postSavePath is required")
+	}
+	if c.ShortcodeSyntax != "" && !containsString(tomarkdown.ExtendedSyntaxTargets, c.ShortcodeSyntax) {
+		problems = append(problems, fmt.Sprintf("markdown.shortcodeSyntax %q is unknown, use one of: %s",
+			c.ShortcodeSyntax, strings.Join(tomarkdown.ExtendedSyntaxTargets, ", ")))
+	}
+	if c.ShortcodeSyntax == tomarkdown.CustomSyntaxTarget && c.ShortcodeTemplateDir == "" {
+		problems = append(problems, "markdown.shortcodeSyntax custom needs markdown.shortcodeTemplateDir")
+	}
+	if c.LinkStyle != "" && c.LinkStyle != tomarkdown.LinkStyleInline && c.LinkStyle != tomarkdown.LinkStyleReference {
+		problems = append(problems, fmt.Sprintf("markdown.linkStyle %q is unknown, use %s or %s",
+			c.LinkStyle, tomarkdown.LinkStyleInline, tomarkdown.LinkStyleReference))
+	}
+	if c.InternalLinkStyle != "" && c.InternalLinkStyle != tomarkdown.InternalLinkStyleMarkdown &&
+		c.InternalLinkStyle != tomarkdown.InternalLinkStyleWikilink {
+		problems = append(problems, fmt.Sprintf("markdown.internalLinkStyle %q is unknown, use %s or %s",
+			c.InternalLinkStyle, tomarkdown.InternalLinkStyleMarkdown, tomarkdown.InternalLinkStyleWikilink))
+	}
+	if c.CodeCaption != "" && c.CodeCaption != tomarkdown.CodeCaptionLine && c.CodeCaption != tomarkdown.CodeCaptionTitle {
+		problems = append(problems, fmt.Sprintf("markdown.codeCaption %q is unknown, use %s or %s",
+			c.CodeCaption, tomarkdown.CodeCaptionLine, tomarkdown.CodeCaptionTitle))
+	}
+	if c.FrontMatterFormat != "" && !containsString(tomarkdown.FrontMatterFormats, c.FrontMatterFormat) {
+		problems = append(problems, fmt.Sprintf("markdown.frontMatterFormat %q is unknown, use one of: %s",
+			c.FrontMatterFormat, strings.Join(tomarkdown.FrontMatterFormats, ", ")))
+	}
+	if c.WideTableWrapper != "" && !strings.Contains(c.WideTableWrapper, "%s") {
+		problems = append(problems, "markdown.wideTableWrapper needs a %s where the table goes")
+	}
+	if c.ImageConvert != "" && !containsString(tomarkdown.ImageFormats(), strings.ToLower(c.ImageConvert)) {
+		problems = append(problems, fmt.Sprintf("markdown.imageConvert %q has no registered encoder, use one of: %s",
+			c.ImageConvert, strings.Join(tomarkdown.ImageFormats(), ", ")))
+	}
+	if c.LogFormat != "" && c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		problems = append(problems, fmt.Sprintf("logFormat %q is unknown, use %s or %s", c.LogFormat, LogFormatText, LogFormatJSON))
+	}
+	if c.Parallelism < 0 {
+		problems = append(problems, "parallelism must not be negative")
+	}
+	if c.RequestsPerSecond < 0 {
+		problems = append(problems, "requestsPerSecond must not be negative")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 func DefaultConfigInit() error {
 	defaultCfg := &Config{
 		Notion: Notion{
-			DatabaseID:     "YOUR-NOTION-DATABASE-ID",
+			DatabaseID:     placeholderDatabaseID,
 			FilterProp:     "Status",
 			FilterValue:    []string{"Finished", "Published"},
 			PublishedValue: "Published",
@@ -58,8 +372,10 @@ func DefaultConfigInit() error {
 		Parallelize: true,
 		// default to 4 concurrent fetches
 		Parallelism: 4,
-		Incremental: true,
-		CacheFile:   ".notion-md-gen-cache.json",
+		// notion allows an average of three requests per second
+		RequestsPerSecond: 3,
+		Incremental:       true,
+		CacheFile:         ".notion-md-gen-cache.json",
 	}
 	out, err := yaml.Marshal(defaultCfg)
 	if err != nil {
@@ -0,0 +1,90 @@
+package generator
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() Config {
+	return Config{
+		Notion:   Notion{DatabaseID: "0123456789abcdef"},
+		Markdown: Markdown{ShortcodeSyntax: "hugo", PostSavePath: "content/posts"},
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+
+	tests := map[string]struct {
+		modify  func(c *Config)
+		problem string
+	}{
+		"missing database":                 {func(c *Config) { c.DatabaseID = "" }, "notion.databaseId is required"},
+		"placeholder database":             {func(c *Config) { c.DatabaseID = placeholderDatabaseID }, "placeholder"},
+		"missing post path":                {func(c *Config) { c.PostSavePath = "" }, "markdown.postSavePath is required"},
+		"unknown shortcodes":               {func(c *Config) { c.ShortcodeSyntax = "jekyll" }, `"jekyll" is unknown, use one of: hugo, hexo, vuepress`},
+		"custom without templates":         {func(c *Config) { c.ShortcodeSyntax = "custom" }, "markdown.shortcodeTemplateDir"},
+		"unknown link style":               {func(c *Config) { c.LinkStyle = "footnote" }, `markdown.linkStyle "footnote"`},
+		"unknown internal link style":      {func(c *Config) { c.InternalLinkStyle = "roam" }, `markdown.internalLinkStyle "roam"`},
+		"unknown code caption":             {func(c *Config) { c.CodeCaption = "footer" }, `markdown.codeCaption "footer"`},
+		"unknown front matter":             {func(c *Config) { c.FrontMatterFormat = "xml" }, `markdown.frontMatterFormat "xml"`},
+		"wide table wrapper without table": {func(c *Config) { c.WideTableWrapper = "<div>" }, "markdown.wideTableWrapper needs a %s"},
+		"image format without encoder":     {func(c *Config) { c.ImageConvert = "avif" }, `markdown.imageConvert "avif" has no registered encoder, use one of: jpeg, png, webp`},
+		"unknown log format":               {func(c *Config) { c.LogFormat = "xml" }, `logFormat "xml"`},
+		"negative parallelism":             {func(c *Config) { c.Parallelism = -1 }, "parallelism must not be negative"},
+		"sort without property":            {func(c *Config) { c.Sorts = []Sort{{Direction: "descending"}} }, "notion.sorts[0] needs either a property or a timestamp"},
+		"unknown sort direction":           {func(c *Config) { c.Sorts = []Sort{{Property: "Date", Direction: "down"}} }, `notion.sorts[0].direction "down"`},
+	}
+	for name, tt := range tests {
+		config := validConfig()
+		tt.modify(&config)
+		err := config.Validate()
+		if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), tt.problem, name)
+		}
+	}
+
+	// a format with an encoder is accepted whatever its case
+	config := validConfig()
+	config.ImageConvert = "PNG"
+	assert.NoError(t, config.Validate())
+
+	// every problem is reported at once
+	err := Config{}.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "notion.databaseId is required")
+		assert.Contains(t, err.Error(), "markdown.postSavePath is required")
+	}
+}
+
+func TestConfigExpandEnv(t *testing.T) {
+	t.Setenv("HOME", "/home/jane")
+	t.Setenv("SITE", "blog")
+
+	var config Config
+	config.PostSavePath = "$HOME/posts"
+	config.ImageSavePath = "${SITE}/static/images"
+	config.CacheFile = "cache-$$HOME.json"
+	config.Headers = map[string]string{"X-Site": "$SITE"}
+	config.ExpandEnv()
+
+	assert.Equal(t, "/home/jane/posts", config.PostSavePath)
+	assert.Equal(t, "blog/static/images", config.ImageSavePath)
+	assert.Equal(t, "cache-$HOME.json", config.CacheFile)
+	assert.Equal(t, "blog", config.Headers["X-Site"])
+}
+
+func TestConfigResolvePaths(t *testing.T) {
+	var config Config
+	config.PostSavePath = "content/posts"
+	config.ImageSavePath = "/srv/images"
+	config.CacheFile = ".notion-md-gen-cache.json"
+	config.ResolvePaths("/site", "/site/content")
+
+	assert.Equal(t, "posts", config.PostSavePath)
+	assert.Equal(t, "/srv/images", config.ImageSavePath)
+	assert.Equal(t, filepath.Join("..", ".notion-md-gen-cache.json"), config.CacheFile)
+	assert.Empty(t, config.ManifestFile)
+}
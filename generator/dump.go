@@ -0,0 +1,33 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// DumpPage writes the block tree of a page as indented JSON, in the shape of
+// the testdata of the tomarkdown package.
+func DumpPage(config Config, pageID string, w io.Writer) error {
+	client, err := newClient(config)
+	if err != nil {
+		return err
+	}
+	return dumpPage(client, pageID, blockDepth(config.Markdown), w)
+}
+
+func dumpPage(client NotionAPI, pageID string, maxDepth int, w io.Writer) error {
+	blocks, err := retrieveBlockChildren(client, pageID, maxDepth)
+	if err != nil {
+		return fmt.Errorf("fetching blocks of page %s: %w", pageID, err)
+	}
+	if blocks == nil {
+		blocks = []notion.Block{}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(blocks)
+}
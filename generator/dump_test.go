@@ -0,0 +1,46 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpPage(t *testing.T) {
+	responses := map[string]string{
+		"/v1/blocks/page-1/children": `{"object": "list", "has_more": false, "results": [
+			{"object": "block", "id": "block-1", "type": "bulleted_list_item", "has_children": true,
+			 "bulleted_list_item": {"text": [{"type": "text", "text": {"content": "Parent"}}]}}]}`,
+		"/v1/blocks/block-1/children": `{"object": "list", "has_more": false, "results": [
+			{"object": "block", "id": "block-2", "type": "paragraph",
+			 "paragraph": {"text": [{"type": "text", "text": {"content": "Child"}}]}}]}`,
+	}
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body, ok := responses[req.URL.Path]
+			if !ok {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}),
+	}))
+
+	var out bytes.Buffer
+	assert.NoError(t, dumpPage(client, "page-1", DefaultMaxBlockDepth, &out))
+
+	var blocks []notion.Block
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &blocks))
+	if assert.Len(t, blocks, 1) {
+		assert.Equal(t, "Parent", blocks[0].BulletedListItem.Text[0].Text.Content)
+		children := blocks[0].BulletedListItem.Children
+		if assert.Len(t, children, 1) {
+			assert.Equal(t, "Child", children[0].Paragraph.Text[0].Text.Content)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// pageEditors keeps the users who created and last edited the pages seen in
+// page and database query responses, go-notion doesn't decode them. Their
+// names are looked up once per user. It is safe for concurrent use.
+type pageEditors struct {
+	mu    sync.Mutex
+	pages map[string][2]string // page ID: created_by and last_edited_by user IDs
+	names map[string]string
+}
+
+// newPageEditors returns a recorder when config writes the editors of pages
+// to the front matter, nil otherwise.
+func newPageEditors(config Markdown) *pageEditors {
+	if config.CreatedByField == "" && config.LastEditedByField == "" {
+		return nil
+	}
+	return &pageEditors{pages: make(map[string][2]string), names: make(map[string]string)}
+}
+
+type editedPage struct {
+	Object       string              `json:"object"`
+	ID           string              `json:"id"`
+	CreatedBy    struct{ ID string } `json:"created_by"`
+	LastEditedBy struct{ ID string } `json:"last_edited_by"`
+}
+
+// record stores the editors of the pages in a page or database query response body
+func (e *pageEditors) record(req *http.Request, body []byte) {
+	if !strings.Contains(req.URL.Path, "/pages/") && !strings.HasSuffix(req.URL.Path, "/query") {
+		return
+	}
+	var resp struct {
+		editedPage
+		Results []editedPage `json:"results"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, page := range append(resp.Results, resp.editedPage) {
+		if page.Object == "page" && page.ID != "" {
+			e.pages[page.ID] = [2]string{page.CreatedBy.ID, page.LastEditedBy.ID}
+		}
+	}
+}
+
+// editors returns the names of the users who created and last edited a page,
+// empty when unknown or when the integration may not read users.
+func (e *pageEditors) editors(client NotionAPI, pageID string) (createdBy, lastEditedBy string) {
+	e.mu.Lock()
+	users := e.pages[pageID]
+	e.mu.Unlock()
+	return e.userName(client, users[0]), e.userName(client, users[1])
+}
+
+func (e *pageEditors) userName(client NotionAPI, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	e.mu.Lock()
+	name, ok := e.names[userID]
+	e.mu.Unlock()
+	if ok {
+		return name
+	}
+	if user, err := client.FindUserByID(context.Background(), userID); err == nil {
+		name = user.Name
+	}
+	e.mu.Lock()
+	e.names[userID] = name
+	e.mu.Unlock()
+	return name
+}
@@ -0,0 +1,166 @@
+package generator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bonaysoft/notion-md-gen/pkg/tomarkdown"
+	"github.com/dstotijn/go-notion"
+)
+
+// propertyFilter matches pages whose property Name has the value Value.
+type propertyFilter struct {
+	Name  string
+	Value string
+}
+
+// parsePropertyFilters parses filters written as "Property=Value".
+func parsePropertyFilters(args []string) ([]propertyFilter, error) {
+	filters := make([]propertyFilter, 0, len(args))
+	for _, arg := range args {
+		idx := strings.Index(arg, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid filter %q, expected Property=Value", arg)
+		}
+		filters = append(filters, propertyFilter{
+			Name:  strings.TrimSpace(arg[:idx]),
+			Value: strings.TrimSpace(arg[idx+1:]),
+		})
+	}
+	return filters, nil
+}
+
+// match reports whether one of the values of the filtered property equals
+// f.Value. Property names and values are compared case-insensitively.
+func (f propertyFilter) match(page notion.Page) bool {
+	props, ok := page.Properties.(notion.DatabasePageProperties)
+	if !ok {
+		return false
+	}
+	for key, prop := range props {
+		if !strings.EqualFold(key, f.Name) {
+			continue
+		}
+		for _, value := range propertyValues(prop) {
+			if strings.EqualFold(value, f.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// propertyValues returns the values of a page property as strings; multi
+// selects, people and relations yield one value per entry.
+func propertyValues(property notion.DatabasePageProperty) []string {
+	switch prop := tomarkdown.PropertyValue(property).(type) {
+	case []notion.RichText:
+		return []string{tomarkdown.ConvertRichText(prop)}
+	case *notion.SelectOptions:
+		if prop != nil {
+			return []string{prop.Name}
+		}
+	case []notion.SelectOptions:
+		values := make([]string, 0, len(prop))
+		for _, option := range prop {
+			values = append(values, option.Name)
+		}
+		return values
+	case []notion.User:
+		values := make([]string, 0, len(prop))
+		for _, user := range prop {
+			values = append(values, user.Name)
+		}
+		return values
+	case *bool:
+		if prop != nil {
+			return []string{strconv.FormatBool(*prop)}
+		}
+	case *float64:
+		if prop != nil {
+			return []string{strconv.FormatFloat(*prop, 'f', -1, 64)}
+		}
+	case *notion.Date:
+		// the start date as Notion writes it, with the time when it has one
+		if prop != nil {
+			if start, err := prop.Start.MarshalJSON(); err == nil {
+				return []string{strings.Trim(string(start), `"`)}
+			}
+		}
+	case *string:
+		if prop != nil {
+			return []string{*prop}
+		}
+	}
+	return nil
+}
+
+// filterPages keeps the pages edited after since (when set) whose title
+// contains every keyword and which match every property filter.
+func filterPages(pages []notion.Page, keywords []string, filters []propertyFilter, since *time.Time, titleProperty string) []notion.Page {
+	filtered := []notion.Page{}
+	for _, page := range pages {
+		// --since filter (last edited time)
+		if since != nil && !page.LastEditedTime.After(*since) {
+			continue
+		}
+
+		// title keyword filter
+		if len(keywords) > 0 {
+			pageTitle := getPageTitle(page, titleProperty)
+			if pageTitle == "" {
+				continue // skip pages without a title for keyword filtering
+			}
+			lowerTitle := strings.ToLower(pageTitle)
+			matchAllKeywords := true
+			for _, arg := range keywords {
+				if !strings.Contains(lowerTitle, strings.ToLower(arg)) {
+					matchAllKeywords = false
+					break
+				}
+			}
+			if !matchAllKeywords {
+				continue // skip if title doesn't match all keywords
+			}
+		}
+
+		// property filters
+		matchAllFilters := true
+		for _, filter := range filters {
+			if !filter.match(page) {
+				matchAllFilters = false
+				break
+			}
+		}
+		if !matchAllFilters {
+			continue
+		}
+
+		// if we got here, the page passed all active filters
+		filtered = append(filtered, page)
+	}
+	return filtered
+}
+
+// limitPages returns the first limit pages, or all of them when limit is not positive.
+func limitPages(pages []notion.Page, limit int) []notion.Page {
+	if limit <= 0 || len(pages) <= limit {
+		return pages
+	}
+	return pages[:limit]
+}
+
+// withoutArchived splits the archived pages off pages, keeping the order of both.
+func withoutArchived(pages []notion.Page) (kept, archived []notion.Page) {
+	kept = make([]notion.Page, 0, len(pages))
+	for _, page := range pages {
+		if page.Archived {
+			archived = append(archived, page)
+			continue
+		}
+		kept = append(kept, page)
+	}
+	return kept, archived
+}
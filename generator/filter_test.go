@@ -0,0 +1,79 @@
+package generator
+
+import (
+	"testing"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTaggedPage returns a page with a Status select and a Tags multi select
+func testTaggedPage(id, title, status string, tags ...string) notion.Page {
+	page := testPage(id, title)
+	props := page.Properties.(notion.DatabasePageProperties)
+	props["Status"] = notion.DatabasePageProperty{
+		Type:   notion.DBPropTypeSelect,
+		Select: &notion.SelectOptions{Name: status},
+	}
+	options := make([]notion.SelectOptions, 0, len(tags))
+	for _, tag := range tags {
+		options = append(options, notion.SelectOptions{Name: tag})
+	}
+	props["Tags"] = notion.DatabasePageProperty{Type: notion.DBPropTypeMultiSelect, MultiSelect: options}
+	return page
+}
+
+func TestFilterPagesByProperty(t *testing.T) {
+	pages := []notion.Page{
+		testTaggedPage("page-1", "Go Generics", "Published", "go"),
+		testTaggedPage("page-2", "Rust Traits", "Published", "rust"),
+		testTaggedPage("page-3", "Go Modules", "Draft", "go", "tooling"),
+	}
+
+	filters, err := parsePropertyFilters([]string{"Status=Published"})
+	assert.NoError(t, err)
+	assert.Len(t, filterPages(pages, nil, filters, nil, ""), 2)
+
+	filters, err = parsePropertyFilters([]string{"status=published", "Tags=Go"})
+	assert.NoError(t, err)
+	matched := filterPages(pages, nil, filters, nil, "")
+	assert.Len(t, matched, 1)
+	assert.Equal(t, "page-1", matched[0].ID)
+
+	// keywords and property filters are combined
+	filters, err = parsePropertyFilters([]string{"Tags=go"})
+	assert.NoError(t, err)
+	matched = filterPages(pages, []string{"modules"}, filters, nil, "")
+	assert.Len(t, matched, 1)
+	assert.Equal(t, "page-3", matched[0].ID)
+
+	_, err = parsePropertyFilters([]string{"Status"})
+	assert.Error(t, err)
+}
+
+func TestLimitPages(t *testing.T) {
+	pages := []notion.Page{testPage("a", "A"), testPage("b", "B"), testPage("c", "C")}
+
+	limited := limitPages(pages, 2)
+	assert.Len(t, limited, 2)
+	assert.Equal(t, "a", limited[0].ID)
+	assert.Equal(t, "b", limited[1].ID)
+
+	assert.Len(t, limitPages(pages, 0), 3)
+	assert.Len(t, limitPages(pages, -1), 3)
+	assert.Len(t, limitPages(pages, 5), 3)
+}
+
+func TestWithoutArchived(t *testing.T) {
+	archivedPage := testPage("b", "Archived")
+	archivedPage.Archived = true
+	pages := []notion.Page{testPage("a", "A"), archivedPage, testPage("c", "C")}
+
+	kept, archived := withoutArchived(pages)
+	assert.Len(t, kept, 2)
+	assert.Equal(t, "a", kept[0].ID)
+	assert.Equal(t, "c", kept[1].ID)
+	if assert.Len(t, archived, 1) {
+		assert.Equal(t, "b", archived[0].ID)
+	}
+}
@@ -1,7 +1,10 @@
 package generator
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -10,17 +13,25 @@ import (
 	"time"
 
 	"github.com/bonaysoft/notion-md-gen/pkg/tomarkdown"
-	"github.com/hashicorp/go-retryablehttp"
 
 	"github.com/dstotijn/go-notion"
 )
 
 // getpagetitle extracts the plain text title from page properties.
-func getPageTitle(page notion.Page) string {
+func getPageTitle(page notion.Page, titleProperty string) string {
 	props, ok := page.Properties.(notion.DatabasePageProperties)
 	if !ok {
 		return "" // or page.id if preferred as fallback
 	}
+	// an explicitly configured title column wins over the heuristics below,
+	// whatever its type, unless it is empty
+	if titleProperty != "" {
+		if prop, ok := props[titleProperty]; ok {
+			if title := strings.Join(propertyValues(prop), ", "); title != "" {
+				return title
+			}
+		}
+	}
 	// look for a property named "title" or "name" case-insensitively
 	for key, prop := range props {
 		if prop.Type == notion.DBPropTypeTitle && (strings.EqualFold(key, "title") || strings.EqualFold(key, "name")) {
@@ -39,10 +50,59 @@ func getPageTitle(page notion.Page) string {
 	return "" // no title found
 }
 
+// resolvePageName returns the name used for the files of a page: its title,
+// or for untitled pages config.UntitledName followed by the page ID, or just
+// the page ID when no UntitledName is configured.
+func resolvePageName(page notion.Page, config Markdown) string {
+	if title := getPageTitle(page, config.TitleProperty); strings.TrimSpace(title) != "" {
+		return title
+	}
+	if config.UntitledName != "" {
+		return config.UntitledName + "-" + page.ID
+	}
+	return page.ID
+}
+
 func Run(config Config, filterArgs []string, since *time.Time, dryRun bool) error {
+	if config.ZipFile != "" {
+		return runZip(config, filterArgs, since, dryRun)
+	}
+	return run(config, filterArgs, since, dryRun)
+}
+
+func run(config Config, filterArgs []string, since *time.Time, dryRun bool) error {
+	started := time.Now()
+	logger := newRunLogger(os.Stdout, config.Progress && !config.Verbose, config.LogFormat)
+	if logger.quiet() {
+		// the spinner would fight with the progress bar or break the JSON lines
+		spin.Writer = io.Discard
+	}
+
+	// go-notion drops the width of columns and the color of callouts, keep them while fetching the blocks
+	config.Markdown.columnWidths = newColumnWidths()
+	config.Markdown.blockColors = newBlockColors()
+	config.Markdown.editors = newPageEditors(config.Markdown)
+	config.Markdown.imageTransport = config.transport
+	config.Markdown.logger = logger
+	parallelism := 1
+	if config.Parallelize && config.Parallelism > 0 {
+		parallelism = config.Parallelism
+	}
+	config.workers = newWorkerLimiter(parallelism, config.AdaptiveParallelism)
+	// fail before touching any files when the API can't be reached anyway
+	client, err := newClient(config)
+	if err != nil {
+		return err
+	}
+
 	if config.CacheFile == "" {
 		config.CacheFile = ".notion-md-gen-cache.json"
 	}
+	// a single-file export always contains every matching page
+	if config.Markdown.SingleFile != "" {
+		config.Incremental = false
+		config.SinceCache = false
+	}
 
 	if err := os.MkdirAll(config.Markdown.PostSavePath, 0755); err != nil {
 		// even in dry run, we might need the path conceptually, but check if it exists
@@ -53,79 +113,104 @@ func Run(config Config, filterArgs []string, since *time.Time, dryRun bool) erro
 		}
 	}
 
+	filters, err := parsePropertyFilters(config.Filters)
+	if err != nil {
+		return err
+	}
+
 	// find database page
-	client := notion.NewClient(os.Getenv("NOTION_SECRET"), notion.WithHTTPClient(retryablehttp.NewClient().StandardClient()))
 	q, err := queryDatabase(client, config.Notion)
 	if err != nil {
 		return fmt.Errorf("❌ Querying Notion database: %s", err)
 	}
-	fmt.Println("✔ Querying Notion database: Completed")
+	logger.infof("✔ Querying Notion database: Completed\n")
+
+	// archived pages are stale, leave them out of the export (and let --prune remove them)
+	if !config.IncludeArchived {
+		var archived []notion.Page
+		q.Results, archived = withoutArchived(q.Results)
+		for _, page := range archived {
+			logger.pagef("[%-30s] skipped: archived\n", resolvePageName(page, config.Markdown))
+			logger.pageResult(page.ID, getPageTitle(page, config.TitleProperty), pageStatusSkipped, time.Now(), nil)
+		}
+		if len(archived) > 0 {
+			logger.infof("✔ Skipped %d archived pages\n", len(archived))
+		}
+	}
 
-	// filter pages based on args and --since flag
-	pagesToProcess := []notion.Page{}
-	filterActive := len(filterArgs) > 0 || since != nil
-	if filterActive {
-		if len(filterArgs) > 0 {
-			fmt.Printf("Filtering pages by keywords: %v\n", filterArgs)
+	cache := defaultCache()
+	if config.Incremental || config.Prune || config.SinceCache {
+		loadedCache, err := loadCache(config.CacheFile)
+		if err != nil {
+			return fmt.Errorf("failed loading cache file %q: %w", config.CacheFile, err)
 		}
+		cache = loadedCache
+	}
+	if config.SinceCache {
+		since = sinceLastRun(since, cache)
 		if since != nil {
-			// fmt.Printf("Filtering pages modified since: %s\n", since.Format(time.RFC3339)) // already printed in root.go
+			logger.infof("Filtering pages modified since: %s\n", since.Format(time.RFC3339))
 		}
-		for _, page := range q.Results {
-			// --since filter (last edited time)
-			if since != nil && !page.LastEditedTime.After(*since) {
-				continue
-			}
-
-			// title keyword filter
-			if len(filterArgs) > 0 {
-				pageTitle := getPageTitle(page)
-				if pageTitle == "" {
-					continue // skip pages without a title for keyword filtering
-				}
-				lowerTitle := strings.ToLower(pageTitle)
-				matchAllKeywords := true
-				for _, arg := range filterArgs {
-					if !strings.Contains(lowerTitle, strings.ToLower(arg)) {
-						matchAllKeywords = false
-						break
-					}
-				}
-				if !matchAllKeywords {
-					continue // skip if title doesn't match all keywords
-				}
-			}
+	}
+	// saveLastRun records this run in the cache for the next --since-cache run
+	saveLastRun := func() error {
+		if !config.SinceCache || dryRun {
+			return nil
+		}
+		cache.LastRun = cacheTimestamp(started)
+		if err := saveCache(config.CacheFile, cache); err != nil {
+			return fmt.Errorf("failed writing cache file %q: %w", config.CacheFile, err)
+		}
+		return nil
+	}
 
-			// if we got here, the page passed all active filters
-			pagesToProcess = append(pagesToProcess, page)
+	// filter pages based on args, --filter and --since flags
+	pagesToProcess := []notion.Page{}
+	filterActive := len(filterArgs) > 0 || len(filters) > 0 || since != nil
+	if filterActive {
+		if len(filterArgs) > 0 {
+			logger.infof("Filtering pages by keywords: %v\n", filterArgs)
+		}
+		if len(filters) > 0 {
+			logger.infof("Filtering pages by properties: %v\n", config.Filters)
 		}
-		fmt.Printf("✔ Filtering completed: %d pages matched\n", len(pagesToProcess))
+		pagesToProcess = filterPages(q.Results, filterArgs, filters, since, config.TitleProperty)
+		logger.infof("✔ Filtering completed: %d pages matched\n", len(pagesToProcess))
 	} else {
 		pagesToProcess = q.Results // no filters, process all pages
 	}
-
-	if len(pagesToProcess) == 0 {
-		fmt.Println("No pages found matching the criteria.")
-		return nil // exit gracefully if no pages match
+	if limited := limitPages(pagesToProcess, config.Limit); len(limited) < len(pagesToProcess) {
+		logger.infof("✔ Limit: processing the first %d of %d pages\n", len(limited), len(pagesToProcess))
+		pagesToProcess = limited
 	}
 
-	cache := defaultCache()
-	if config.Incremental {
-		loadedCache, err := loadCache(config.CacheFile)
+	// prune against the full query result, so keyword and --since filters never remove files
+	if config.Prune {
+		pruned, err := pruneOrphans(cache, q.Results, config.Markdown, dryRun, logger)
 		if err != nil {
-			return fmt.Errorf("failed loading cache file %q: %w", config.CacheFile, err)
+			return fmt.Errorf("failed pruning orphaned files: %w", err)
 		}
-		cache = loadedCache
+		if pruned > 0 && !dryRun {
+			if err := saveCache(config.CacheFile, cache); err != nil {
+				return fmt.Errorf("failed writing cache file %q: %w", config.CacheFile, err)
+			}
+			logger.infof("✔ Pruned %d orphaned pages\n", pruned)
+		}
+	}
+
+	if len(pagesToProcess) == 0 {
+		logger.infof("No pages found matching the criteria.\n")
+		return saveLastRun() // exit gracefully if no pages match
 	}
 
+	exported := &manifest{}
 	unchangedSkipped := 0
+	// pages generated by an interrupted run that stopped before changing their status
+	var statusPending []notion.Page
 	filteredPages := make([]notion.Page, 0, len(pagesToProcess))
 	for _, page := range pagesToProcess {
-		title := getPageTitle(page)
-		if title == "" {
-			title = page.ID
-		}
-		outputRelPath := generateArticleFilename(title, page.CreatedTime, config.Markdown)
+		title := resolvePageName(page, config.Markdown)
+		outputRelPath := pageFilename(page, title, config.Markdown)
 		outputAbsPath := filepath.Join(config.Markdown.PostSavePath, outputRelPath)
 		pageEditedAt := cacheTimestamp(page.LastEditedTime)
 
@@ -133,7 +218,12 @@ func Run(config Config, filterArgs []string, since *time.Time, dryRun bool) erro
 		skipAsUnchanged := config.Incremental && found && entry.LastEdited == pageEditedAt
 		if skipAsUnchanged {
 			if _, err := os.Stat(outputAbsPath); err == nil {
+				if entry.StatusPending {
+					statusPending = append(statusPending, page)
+				}
 				unchangedSkipped++
+				exported.add(page, getPageTitle(page, config.TitleProperty), outputRelPath)
+				logger.pageResult(page.ID, title, pageStatusSkipped, time.Now(), nil)
 				continue
 			}
 		}
@@ -143,35 +233,90 @@ func Run(config Config, filterArgs []string, since *time.Time, dryRun bool) erro
 
 	// handle dry run: print titles and exit
 	if dryRun {
-		fmt.Println("\n-- Dry Run Active --")
-		fmt.Println("Articles that would be processed:")
+		logger.infof("\n-- Dry Run Active --\n")
+		logger.infof("Articles that would be processed:\n")
 		for i, page := range pagesToProcess {
-			title := getPageTitle(page)
+			title := getPageTitle(page, config.TitleProperty)
 			if title == "" {
 				title = "[Untitled Page: " + page.ID + "]"
 			}
-			fmt.Printf("  %d: %s (ID: %s, LastEdited: %s)\n", i+1, title, page.ID, page.LastEditedTime.Local().Format(time.RFC822))
+			logger.infof("  %d: %s (ID: %s, LastEdited: %s)\n", i+1, title, page.ID, page.LastEditedTime.Local().Format(time.RFC822))
 		}
 		return nil
 	}
 
 	if config.Incremental && unchangedSkipped > 0 {
-		fmt.Printf("✔ Incremental sync: skipped %d unchanged pages\n", unchangedSkipped)
+		logger.infof("✔ Incremental sync: skipped %d unchanged pages\n", unchangedSkipped)
+	}
+
+	useCache := config.Incremental || config.Prune || config.SinceCache
+	var cacheMu sync.Mutex
+	// commitPage records a page in the cache file as soon as it is generated,
+	// so a run that is interrupted resumes after the pages it completed
+	commitPage := func(pageID string, entry cacheEntry) error {
+		cacheMu.Lock()
+		defer cacheMu.Unlock()
+		cache.Pages[pageID] = entry
+		if !useCache {
+			return nil
+		}
+		if err := saveCache(config.CacheFile, cache); err != nil {
+			return fmt.Errorf("failed writing cache file %q: %w", config.CacheFile, err)
+		}
+		return nil
+	}
+
+	changed := 0 // number of article status changed
+	for _, page := range statusPending {
+		if changeStatus(client, page, config.Notion, logger) {
+			changed++
+		}
+		entry := cache.Pages[page.ID]
+		entry.StatusPending = false
+		if err := commitPage(page.ID, entry); err != nil {
+			return err
+		}
 	}
 
 	if len(pagesToProcess) == 0 {
-		fmt.Println("No changed pages to process.")
+		logger.infof("No changed pages to process.\n")
+		if err := saveLastRun(); err != nil {
+			return err
+		}
+		return writeManifest(exported, config.ManifestFile, logger)
+	}
+
+	// bookmarks shared across pages are looked up only once per run
+	bookmarks, err := tomarkdown.LoadBookmarkCache(config.BookmarkCacheFile)
+	if err != nil {
+		return fmt.Errorf("failed loading bookmark cache %q: %w", config.BookmarkCacheFile, err)
+	}
+	config.Markdown.bookmarks = bookmarks
+	// parallel pages share one bound on simultaneous image downloads
+	config.Markdown.imageDownloads = newDownloadLimiter(config.Markdown)
+	// the same image used by several pages is saved once
+	config.Markdown.imageIndex = tomarkdown.NewImageIndex()
+
+	logger.start(len(pagesToProcess))
+
+	if config.Markdown.SingleFile != "" {
+		if err := exportSingleFile(client, pagesToProcess, config, logger); err != nil {
+			return err
+		}
+		if err := bookmarks.Save(config.BookmarkCacheFile); err != nil {
+			return fmt.Errorf("failed writing bookmark cache %q: %w", config.BookmarkCacheFile, err)
+		}
 		return nil
 	}
 
+	// links between pages point at the generated files of every page in the export
+	config.Markdown.pages = newPageIndex(q.Results, config.Markdown)
+
 	// helper to fetch, generate, and update status for a page (only runs if not dryRun)
-	handlePage := func(page notion.Page, blocks []notion.Block, displayName string, previousOutputRelPath string) (string, error) {
-		fmt.Printf("[%-30s] ✔ getting blocks tree: completed\n", displayName)
-		title := getPageTitle(page)
-		if title == "" {
-			title = page.ID
-		}
-		outputRelPath := generateArticleFilename(title, page.CreatedTime, config.Markdown)
+	handlePage := func(page notion.Page, blocks []notion.Block, displayName string, previousOutputRelPath string) (cacheEntry, error) {
+		logger.pagef("[%-30s] ✔ getting blocks tree: completed\n", displayName)
+		title := resolvePageName(page, config.Markdown)
+		outputRelPath := pageFilename(page, title, config.Markdown)
 		outputAbsPath := filepath.Join(config.Markdown.PostSavePath, outputRelPath)
 
 		if config.Incremental && previousOutputRelPath != "" && previousOutputRelPath != outputRelPath {
@@ -181,59 +326,69 @@ func Run(config Config, filterArgs []string, since *time.Time, dryRun bool) erro
 			}
 		}
 
-		if err := generate(page, blocks, config.Markdown, outputAbsPath, title); err != nil {
-			return "", fmt.Errorf("[%-30s] error generating blog post: %v", displayName, err)
+		if err := generate(client, page, blocks, config.Markdown, outputAbsPath, title); err != nil {
+			return cacheEntry{}, fmt.Errorf("[%-30s] error generating blog post: %w", displayName, err)
 		}
-		fmt.Printf("[%-30s] ✔ generating blog post: completed\n", displayName)
-		return outputRelPath, nil
+		logger.pagef("[%-30s] ✔ generating blog post: completed\n", displayName)
+		return cacheEntry{
+			LastEdited: cacheTimestamp(page.LastEditedTime),
+			OutputPath: outputRelPath,
+			ImagePath:  filepath.Join(config.Markdown.ImageSavePath, title),
+		}, nil
 	}
 
-	changed := 0 // number of article status changed
-
 	if config.Parallelize {
-		// fetch and render pages in parallel using a bounded semaphore
-		sem := make(chan struct{}, config.Parallelism)
+		// fetch and render pages in parallel, bounded by the worker limiter
 		errCh := make(chan error, len(pagesToProcess))
 		var wg sync.WaitGroup
 		var mu sync.Mutex
 
 		for i, page := range pagesToProcess {
-			displayName := getPageDisplayName(i, page)
-			sem <- struct{}{}
+			displayName := getPageDisplayName(i, page, config.TitleProperty)
+			config.workers.acquire()
 			wg.Add(1)
 			go func(i int, page notion.Page, displayName string) {
 				defer wg.Done()
-				defer func() { <-sem }()
-				fmt.Printf("[%-30s] -- article [%d/%d] --\n", displayName, i+1, len(pagesToProcess))
-				blocks, err := queryBlockChildren(client, page.ID)
+				defer config.workers.release()
+				started := time.Now()
+				logger.pagef("[%-30s] -- article [%d/%d] --\n", displayName, i+1, len(pagesToProcess))
+				blocks, err := queryBlockChildren(client, page.ID, blockDepth(config.Markdown))
 				if err != nil {
-					errCh <- fmt.Errorf("[%-30s] error getting blocks: %v", displayName, err)
+					err = fmt.Errorf("[%-30s] error getting blocks: %v", displayName, err)
+					logger.pageResult(page.ID, getPageTitle(page, config.TitleProperty), pageStatusFailed, started, err)
+					errCh <- err
 					return
 				}
 				var previousOutputRelPath string
 				if config.Incremental {
+					cacheMu.Lock()
 					if prev, ok := cache.Pages[page.ID]; ok {
 						previousOutputRelPath = prev.OutputPath
 					}
+					cacheMu.Unlock()
 				}
-				outputRelPath, err := handlePage(page, blocks, displayName, previousOutputRelPath)
+				entry, statusChanged, err := publishAfter(
+					func() (cacheEntry, error) { return handlePage(page, blocks, displayName, previousOutputRelPath) },
+					func(entry cacheEntry) error { return commitPage(page.ID, entry) },
+					func() bool { return changeStatus(client, page, config.Notion, logger) },
+				)
 				if err != nil {
+					logger.pageResult(page.ID, getPageTitle(page, config.TitleProperty), pageStatusFailed, started, err)
 					errCh <- err
 					return
 				}
-				statusChanged := changeStatus(client, page, config.Notion)
 				mu.Lock()
-				cache.Pages[page.ID] = cacheEntry{
-					LastEdited: cacheTimestamp(page.LastEditedTime),
-					OutputPath: outputRelPath,
-				}
+				exported.add(page, getPageTitle(page, config.TitleProperty), entry.OutputPath)
 				if statusChanged {
 					changed++
 				}
 				mu.Unlock()
+				logger.pageResult(page.ID, getPageTitle(page, config.TitleProperty), pageStatusGenerated, started, nil)
+				logger.pageDone()
 			}(i, page, displayName)
 		}
 		wg.Wait()
+		logger.finish()
 		close(errCh)
 		for err := range errCh {
 			if err != nil {
@@ -243,11 +398,14 @@ func Run(config Config, filterArgs []string, since *time.Time, dryRun bool) erro
 	} else {
 		// sequential fallback
 		for i, page := range pagesToProcess {
-			displayName := getPageDisplayName(i, page)
-			fmt.Printf("[%-30s] -- article [%d/%d] --\n", displayName, i+1, len(pagesToProcess))
-			blocks, err := queryBlockChildren(client, page.ID)
+			displayName := getPageDisplayName(i, page, config.TitleProperty)
+			started := time.Now()
+			logger.pagef("[%-30s] -- article [%d/%d] --\n", displayName, i+1, len(pagesToProcess))
+			blocks, err := queryBlockChildren(client, page.ID, blockDepth(config.Markdown))
 			if err != nil {
-				return fmt.Errorf("[%-30s] error getting blocks: %v", displayName, err)
+				err = fmt.Errorf("[%-30s] error getting blocks: %v", displayName, err)
+				logger.pageResult(page.ID, getPageTitle(page, config.TitleProperty), pageStatusFailed, started, err)
+				return err
 			}
 			var previousOutputRelPath string
 			if config.Incremental {
@@ -255,66 +413,314 @@ func Run(config Config, filterArgs []string, since *time.Time, dryRun bool) erro
 					previousOutputRelPath = prev.OutputPath
 				}
 			}
-			outputRelPath, err := handlePage(page, blocks, displayName, previousOutputRelPath)
+			entry, statusChanged, err := publishAfter(
+				func() (cacheEntry, error) { return handlePage(page, blocks, displayName, previousOutputRelPath) },
+				func(entry cacheEntry) error { return commitPage(page.ID, entry) },
+				func() bool { return changeStatus(client, page, config.Notion, logger) },
+			)
 			if err != nil {
+				logger.pageResult(page.ID, getPageTitle(page, config.TitleProperty), pageStatusFailed, started, err)
 				return err
 			}
-			cache.Pages[page.ID] = cacheEntry{
-				LastEdited: cacheTimestamp(page.LastEditedTime),
-				OutputPath: outputRelPath,
-			}
-			if changeStatus(client, page, config.Notion) {
+			exported.add(page, getPageTitle(page, config.TitleProperty), entry.OutputPath)
+			if statusChanged {
 				changed++
 			}
+			logger.pageResult(page.ID, getPageTitle(page, config.TitleProperty), pageStatusGenerated, started, nil)
+			logger.pageDone()
 		}
+		logger.finish()
 	}
 
-	if config.Incremental {
+	if config.SinceCache {
+		cache.LastRun = cacheTimestamp(started)
+	}
+	if config.Incremental || config.Prune || config.SinceCache {
 		if err := saveCache(config.CacheFile, cache); err != nil {
 			return fmt.Errorf("failed writing cache file %q: %w", config.CacheFile, err)
 		}
-		fmt.Printf("✔ Cache updated: %s\n", config.CacheFile)
+		logger.infof("✔ Cache updated: %s\n", config.CacheFile)
+	}
+
+	if err := bookmarks.Save(config.BookmarkCacheFile); err != nil {
+		return fmt.Errorf("failed writing bookmark cache %q: %w", config.BookmarkCacheFile, err)
+	}
+
+	if err := writeManifest(exported, config.ManifestFile, logger); err != nil {
+		return err
 	}
 
-	fmt.Printf("✔ Sync complete: processed=%d, skipped=%d, status-updated=%d\n", len(pagesToProcess), unchangedSkipped, changed)
+	logger.infof("✔ Sync complete: processed=%d, skipped=%d, status-updated=%d\n", len(pagesToProcess), unchangedSkipped, changed)
 
 	return nil
 }
 
-func generate(page notion.Page, blocks []notion.Block, config Markdown, outputAbsPath string, pageName string) error {
-	// Create file
+// publishAfter generates a page and then changes its Notion status. The status
+// is changed exactly once, and never when the generation failed. The page is
+// committed to the cache before and after the change, an entry left with
+// StatusPending marks a page whose status a resumed run still has to change.
+func publishAfter(generatePage func() (cacheEntry, error), commit func(cacheEntry) error, publish func() bool) (cacheEntry, bool, error) {
+	entry, err := generatePage()
+	if err != nil {
+		return entry, false, err
+	}
+	entry.StatusPending = true
+	if err := commit(entry); err != nil {
+		return entry, false, err
+	}
+	published := publish()
+	entry.StatusPending = false
+	return entry, published, commit(entry)
+}
+
+func generate(client NotionAPI, page notion.Page, blocks []notion.Block, config Markdown, outputAbsPath string, pageName string) error {
+	// rendering errors already name the page
+	content, err := renderPage(client, page, blocks, config, pageName)
+	if err != nil {
+		return err
+	}
 
-	// fmt.Println("Page: ", page.Properties.(notion.DatabasePageProperties)["title"].Title)
-	// fmt.Println("Title: ", page.Properties.(notion.DatabasePageProperties)["title"].Title[0].Text.Content)
-	// pageName := config.PageNamePrefix + tomarkdown.ConvertRichText(page.Properties.(notion.DatabasePageProperties)["Name"].Title)
+	// Create file, in the directory of its month or PathProperty
+	if err := os.MkdirAll(filepath.Dir(outputAbsPath), 0755); err != nil {
+		return fmt.Errorf("page %q (%s): error create folder: %w", pageName, page.ID, err)
+	}
 	f, err := os.Create(outputAbsPath)
 	if err != nil {
-		return fmt.Errorf("error create file: %s", err)
+		return fmt.Errorf("page %q (%s): error create file: %w", pageName, page.ID, err)
 	}
 	defer f.Close()
 
-	// Generate markdown content to the file
+	if _, err = io.Copy(f, content); err != nil {
+		return fmt.Errorf("page %q (%s): error write file: %w", pageName, page.ID, err)
+	}
+	return nil
+}
+
+// GeneratePage renders a Notion page and its blocks to Markdown and returns the
+// result without creating any output file. Images referenced by the page are
+// still saved to config.ImageSavePath. Synced blocks referencing other blocks
+// are resolved only when blocks already contain their children.
+func GeneratePage(page notion.Page, blocks []notion.Block, config Markdown) (io.Reader, error) {
+	return renderPage(nil, page, blocks, config, resolvePageName(page, config))
+}
+
+// PreviewPage fetches the page with pageID and writes its Markdown to w. No
+// files are created and the page status is left alone; images keep pointing
+// at their Notion URLs.
+func PreviewPage(config Config, pageID string, w io.Writer) error {
+	config.Markdown.columnWidths = newColumnWidths()
+	config.Markdown.blockColors = newBlockColors()
+	config.Markdown.editors = newPageEditors(config.Markdown)
+	client, err := newClient(config)
+	if err != nil {
+		return err
+	}
+	return previewPage(client, pageID, config.Markdown, w)
+}
+
+func previewPage(client NotionAPI, pageID string, config Markdown, w io.Writer) error {
+	page, err := client.FindPageByID(context.Background(), pageID)
+	if err != nil {
+		return fmt.Errorf("fetching page %s: %w", pageID, err)
+	}
+	blocks, err := retrieveBlockChildren(client, page.ID, blockDepth(config))
+	if err != nil {
+		return fmt.Errorf("fetching blocks of page %s: %w", pageID, err)
+	}
+
+	config.KeepRemoteImages = true
+	buf, err := renderPage(client, page, blocks, config, resolvePageName(page, config))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, buf)
+	return err
+}
+
+// renderPage generates the front matter and content of a page into a buffer.
+// The client, if any, is used to fetch content referenced by synced blocks.
+func renderPage(client NotionAPI, page notion.Page, blocks []notion.Block, config Markdown, pageName string) (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	tm := newToMarkdown(client, config, pageName)
+	if config.pages != nil {
+		tm.PageLinkResolver = config.pages.linkFrom(pageFilename(page, pageName, config))
+		tm.PageTitleResolver = config.pages.title
+	}
+	tm.WithFrontMatter(page)
+	if err := tm.GenerateTo(blocks, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// newToMarkdown returns a converter configured from the markdown config for the given page
+func newToMarkdown(client NotionAPI, config Markdown, pageName string) *tomarkdown.ToMarkdown {
 	tm := tomarkdown.New()
+	if client != nil {
+		tm.FetchBlockChildren = func(blockID string) ([]notion.Block, error) {
+			return retrieveBlockChildren(client, blockID, blockDepth(config))
+		}
+		tm.QueryDatabasePages = func(databaseID string, limit int) ([]tomarkdown.DatabasePage, error) {
+			return queryDatabasePages(client, databaseID, limit, config)
+		}
+		if config.editors != nil {
+			tm.PageEditors = func(pageID string) (string, string) {
+				return config.editors.editors(client, pageID)
+			}
+		}
+	}
 	tm.ImgSavePath = filepath.Join(config.ImageSavePath, pageName)
 	tm.ImgVisitPath = filepath.Join(config.ImagePublicLink, url.PathEscape(pageName))
 	tm.ContentTemplate = config.Template
-	tm.WithFrontMatter(page)
+	tm.WrapperTemplate = config.WrapperTemplate
+	tm.TemplateDir = config.TemplateDir
+	tm.ShortcodeTemplateDir = config.ShortcodeTemplateDir
+	tm.FrontMatterDefaults = config.FrontMatterDefaults
+	tm.FrontMatterMapping = config.FrontMatterMapping
+	tm.DateRanges = config.DateRanges
+	tm.DisableFrontMatter = config.DisableFrontMatter
+	tm.FrontMatterFormat = config.FrontMatterFormat
+	tm.ToggleHeadingDetails = config.ToggleHeadingDetails
+	tm.CodeCaption = config.CodeCaption
+	tm.WideTableColumns = config.WideTableColumns
+	tm.WideTableWrapper = config.WideTableWrapper
+	tm.ImageTitleSeparator = config.ImageTitleSeparator
+	tm.EquationNumbering = config.EquationNumbering
+	tm.PlainFallback = config.PlainFallback
+	tm.PlainBookmarkTitles = config.PlainBookmarkTitles
+	tm.SyncedBlockMarkers = config.SyncedBlockMarkers
+	tm.KeepRemoteImages = config.KeepRemoteImages
+	tm.ImageClient = newImageClient(config.ImageRetries, config.imageTransport)
+	tm.ImageStore = config.ImageStore
+	tm.BookmarkCache = config.bookmarks
+	tm.ImageDownloads = config.imageDownloads
+	if config.logger != nil {
+		tm.Warn = config.logger.warn
+	}
+	if config.imageIndex != nil {
+		tm.ImageIndex = config.imageIndex
+	}
+	if config.columnWidths != nil {
+		tm.ColumnWidthRatio = config.columnWidths.ratio
+	}
+	if config.blockColors != nil {
+		tm.BlockColor = config.blockColors.color
+	}
+	if config.BookmarkTimeout > 0 {
+		tm.BookmarkClient.Timeout = time.Duration(config.BookmarkTimeout) * time.Second
+	}
+	tm.ImageConvert = config.ImageConvert
+	tm.ImageQuality = config.ImageQuality
+	tm.MaxImageWidth = config.MaxImageWidth
+	tm.InlineImageMaxBytes = config.InlineImageMaxBytes
+	tm.ReadingStats = config.ReadingTime
+	tm.WordsPerMinute = config.WordsPerMinute
+	if config.LinkStyle != "" {
+		tm.LinkStyle = config.LinkStyle
+	}
+	tm.InternalLinkStyle = config.InternalLinkStyle
+	if config.LastmodField != "" {
+		tm.LastmodField = config.LastmodField
+	}
+	tm.PageIDField = config.PageIDField
+	tm.CreatedByField = config.CreatedByField
+	tm.LastEditedByField = config.LastEditedByField
+	tm.LinkedDatabaseRows = config.LinkedDatabaseRows
+	if config.EscapeMarkdown != nil {
+		tm.EscapeMarkdown = *config.EscapeMarkdown
+	}
+	tm.IndentUnit = config.IndentUnit
+	tm.ContinuousNumbering = config.ContinuousNumbering
 	if config.ShortcodeSyntax != "" {
 		tm.EnableExtendedSyntax(config.ShortcodeSyntax)
 	}
+	return tm
+}
 
-	return tm.GenerateTo(blocks, f)
+// exportSingleFile fetches the blocks of every page and writes them all into
+// config.Markdown.SingleFile, keeping the query order.
+func exportSingleFile(client NotionAPI, pages []notion.Page, config Config, logger *runLogger) error {
+	pageBlocks := make([][]notion.Block, len(pages))
+	errCh := make(chan error, len(pages))
+	var wg sync.WaitGroup
+	for i, page := range pages {
+		displayName := getPageDisplayName(i, page, config.TitleProperty)
+		config.workers.acquire()
+		wg.Add(1)
+		go func(i int, page notion.Page, displayName string) {
+			defer wg.Done()
+			defer config.workers.release()
+			blocks, err := queryBlockChildren(client, page.ID, blockDepth(config.Markdown))
+			if err != nil {
+				errCh <- fmt.Errorf("[%-30s] error getting blocks: %v", displayName, err)
+				return
+			}
+			pageBlocks[i] = blocks
+			logger.pagef("[%-30s] ✔ getting blocks tree: completed\n", displayName)
+			logger.pageDone()
+		}(i, page, displayName)
+	}
+	wg.Wait()
+	logger.finish()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := writeSingleFile(client, config.Markdown.SingleFile, pages, pageBlocks, config.Markdown); err != nil {
+		return fmt.Errorf("error generating %s: %v", config.Markdown.SingleFile, err)
+	}
+	logger.infof("✔ Single file export: %d pages written to %s\n", len(pages), config.Markdown.SingleFile)
+
+	changed := 0
+	for _, page := range pages {
+		if changeStatus(client, page, config.Notion, logger) {
+			changed++
+		}
+	}
+	logger.infof("✔ Sync complete: processed=%d, status-updated=%d\n", len(pages), changed)
+	return nil
+}
+
+// writeSingleFile renders pages one after another into a single Markdown file.
+// Each page starts with its title as a heading and pages are separated by a
+// horizontal rule. Front matter is not written in this mode.
+func writeSingleFile(client NotionAPI, path string, pages []notion.Page, pageBlocks [][]notion.Block, config Markdown) error {
+	if dir := filepath.Dir(path); dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error create file: %s", err)
+	}
+	defer f.Close()
+
+	for i, page := range pages {
+		title := getPageTitle(page, config.TitleProperty)
+		if title == "" {
+			title = page.ID
+		}
+		if i > 0 {
+			if _, err := io.WriteString(f, "\n---\n\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(f, "# %s\n\n", title); err != nil {
+			return err
+		}
+		if err := newToMarkdown(client, config, title).GenerateTo(pageBlocks[i], f); err != nil {
+			return fmt.Errorf("[%s] %v", title, err)
+		}
+	}
+	return nil
 }
 
 func generateArticleFilename(title string, date time.Time, config Markdown) string {
-	escapedTitle := strings.ReplaceAll(
-		strings.ToValidUTF8(
-			strings.ToLower(title),
-			"",
-		),
-		" ", "-",
-	)
-	escapedFilename := escapedTitle + ".md"
+	escapedFilename := escapeFilename(title, config) + outputExtension(config)
 
 	if config.GroupByMonth {
 		return filepath.Join(date.Format("2006-01-02"), escapedFilename)
@@ -323,10 +729,176 @@ func generateArticleFilename(title string, date time.Time, config Markdown) stri
 	return escapedFilename
 }
 
+// escapeFilename turns a title into a file or directory name the way
+// config asks for: lowercased with dashes, or only sanitized.
+func escapeFilename(title string, config Markdown) string {
+	if config.Transliterate != nil {
+		title = config.Transliterate(title)
+	} else if config.TransliterateFilenames {
+		title = transliterate(title)
+	}
+	var escapedTitle string
+	if config.PreserveTitleFilename {
+		escapedTitle = sanitizeFilename(title)
+	} else {
+		escapedTitle = strings.ReplaceAll(
+			strings.ToValidUTF8(
+				strings.ToLower(title),
+				"",
+			),
+			" ", "-",
+		)
+	}
+	if strings.Trim(escapedTitle, "-") == "" {
+		// never write a hidden ".md" file
+		escapedTitle = "untitled"
+	}
+	return escapedTitle
+}
+
+// pageFilename returns the path of the generated file of a page relative to
+// PostSavePath. The value of config.PathProperty, if the page has one, is the
+// directory of the file instead of the month of GroupByMonth; "/" in the
+// value nests directories.
+func pageFilename(page notion.Page, title string, config Markdown) string {
+	dir := pageDirectory(page, config)
+	if dir == "" {
+		return generateArticleFilename(title, page.CreatedTime, config)
+	}
+	config.GroupByMonth = false
+	return filepath.Join(dir, generateArticleFilename(title, page.CreatedTime, config))
+}
+
+// pageDirectory returns the escaped value of config.PathProperty of a page,
+// the first one of a multi-select, or "" when there is none
+func pageDirectory(page notion.Page, config Markdown) string {
+	if config.PathProperty == "" {
+		return ""
+	}
+	props, _ := page.Properties.(notion.DatabasePageProperties)
+	prop, ok := props[config.PathProperty]
+	if !ok {
+		return ""
+	}
+	values := propertyValues(prop)
+	if len(values) == 0 {
+		return ""
+	}
+	var segments []string
+	for _, segment := range strings.Split(values[0], "/") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, escapeFilename(segment, config))
+	}
+	return filepath.Join(segments...)
+}
+
+func outputExtension(config Markdown) string {
+	ext := strings.TrimSpace(config.OutputExtension)
+	if ext == "" {
+		return ".md"
+	}
+	return "." + strings.TrimLeft(ext, ".")
+}
+
+// sanitizeFilename keeps title as is except for the characters that are
+// illegal in file names on common file systems, which are replaced by "_".
+func sanitizeFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.ToValidUTF8(title, ""))
+	// Windows drops trailing dots and spaces
+	return strings.TrimRight(name, ". ")
+}
+
+// pruneOrphans removes the generated files of cached pages that are no longer
+// returned by the database query and drops them from the cache. Only paths
+// recorded in the cache are touched. In dry-run mode the files are only listed.
+func pruneOrphans(cache runCache, pages []notion.Page, config Markdown, dryRun bool, logger *runLogger) (int, error) {
+	current := make(map[string]bool, len(pages))
+	// the files of the pages still in the database are never removed, even
+	// when an orphan shares them, e.g. the image folder of a page of the same title
+	var live []string
+	for _, page := range pages {
+		current[page.ID] = true
+		name := resolvePageName(page, config)
+		live = append(live,
+			filepath.Join(config.PostSavePath, pageFilename(page, name, config)),
+			filepath.Join(config.ImageSavePath, name))
+		if entry, ok := cache.Pages[page.ID]; ok {
+			if entry.OutputPath != "" {
+				live = append(live, filepath.Join(config.PostSavePath, entry.OutputPath))
+			}
+			if entry.ImagePath != "" {
+				live = append(live, entry.ImagePath)
+			}
+		}
+	}
+
+	pruned := 0
+	for pageID, entry := range cache.Pages {
+		if current[pageID] {
+			continue
+		}
+		var paths []string
+		if entry.OutputPath != "" {
+			paths = append(paths, filepath.Join(config.PostSavePath, entry.OutputPath))
+		}
+		if isSubPath(config.ImageSavePath, entry.ImagePath) {
+			paths = append(paths, entry.ImagePath)
+		}
+
+		for _, path := range paths {
+			if referencedBy(path, live) {
+				continue
+			}
+			if dryRun {
+				logger.infof("  would prune: %s (ID: %s)\n", path, pageID)
+				continue
+			}
+			if err := os.RemoveAll(path); err != nil {
+				return pruned, err
+			}
+		}
+		if !dryRun {
+			delete(cache.Pages, pageID)
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// referencedBy reports whether path is one of the live paths or contains one
+func referencedBy(path string, live []string) bool {
+	for _, l := range live {
+		if filepath.Clean(l) == filepath.Clean(path) || isSubPath(path, l) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubPath reports whether path is located strictly inside dir
+func isSubPath(dir, path string) bool {
+	if path == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 // getPageDisplayName returns a display name for a page: [index:PageName] or [index:PageID] if no name
-func getPageDisplayName(i int, page notion.Page) string {
+func getPageDisplayName(i int, page notion.Page, titleProperty string) string {
 	// use the new helper function to get the title
-	title := getPageTitle(page)
+	title := getPageTitle(page, titleProperty)
 	if title != "" {
 		return fmt.Sprintf("%d:%s", i+1, title)
 	}
@@ -0,0 +1,668 @@
+package generator
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPage returns a database page with the given ID and title
+func testPage(id, title string) notion.Page {
+	return notion.Page{
+		ID: id,
+		Properties: notion.DatabasePageProperties{
+			"Name": notion.DatabasePageProperty{
+				Type:  notion.DBPropTypeTitle,
+				Title: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: title}}},
+			},
+		},
+	}
+}
+
+// testParagraph returns a paragraph block containing content
+func testParagraph(content string) notion.Block {
+	return notion.Block{
+		Type: notion.BlockTypeParagraph,
+		Paragraph: &notion.RichTextBlock{
+			Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: content}}},
+		},
+	}
+}
+
+func TestWriteSingleFile(t *testing.T) {
+	dir := t.TempDir()
+	pages := []notion.Page{testPage("page-1", "First Post"), testPage("page-2", "Second Post")}
+	pageBlocks := [][]notion.Block{
+		{testParagraph("first body")},
+		{testParagraph("second body")},
+	}
+
+	path := filepath.Join(dir, "handbook.md")
+	assert.NoError(t, writeSingleFile(nil, path, pages, pageBlocks, Markdown{}))
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "# First Post")
+	assert.Contains(t, string(content), "# Second Post")
+	assert.Contains(t, string(content), "\n---\n")
+	assert.Less(t, strings.Index(string(content), "first body"), strings.Index(string(content), "second body"))
+}
+
+func TestGeneratePage(t *testing.T) {
+	page := testPage("page-1", "Hello World")
+	page.Properties.(notion.DatabasePageProperties)["Summary"] = notion.DatabasePageProperty{
+		Type:     notion.DBPropTypeRichText,
+		RichText: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "short summary"}}},
+	}
+
+	r, err := GeneratePage(page, []notion.Block{testParagraph("body text")}, Markdown{})
+	assert.NoError(t, err)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "---\n"))
+	assert.Contains(t, string(content), "summary: short summary")
+	assert.Contains(t, string(content), "name: Hello World")
+	assert.Contains(t, string(content), "body text")
+}
+
+func TestPruneOrphans(t *testing.T) {
+	dir := t.TempDir()
+	config := Markdown{
+		PostSavePath:  filepath.Join(dir, "posts"),
+		ImageSavePath: filepath.Join(dir, "images"),
+	}
+	for _, name := range []string{"kept.md", "removed.md"} {
+		assert.NoError(t, os.MkdirAll(config.PostSavePath, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(config.PostSavePath, name), []byte("content"), 0644))
+	}
+	removedImages := filepath.Join(config.ImageSavePath, "removed")
+	assert.NoError(t, os.MkdirAll(removedImages, 0755))
+	untracked := filepath.Join(config.PostSavePath, "handwritten.md")
+	assert.NoError(t, os.WriteFile(untracked, []byte("content"), 0644))
+
+	cache := defaultCache()
+	cache.Pages["page-kept"] = cacheEntry{OutputPath: "kept.md"}
+	cache.Pages["page-removed"] = cacheEntry{OutputPath: "removed.md", ImagePath: removedImages}
+
+	pruned, err := pruneOrphans(cache, []notion.Page{testPage("page-kept", "Kept")}, config, false, newRunLogger(io.Discard, false, LogFormatText))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+
+	assert.FileExists(t, filepath.Join(config.PostSavePath, "kept.md"))
+	assert.FileExists(t, untracked)
+	assert.NoFileExists(t, filepath.Join(config.PostSavePath, "removed.md"))
+	assert.NoDirExists(t, removedImages)
+	assert.Contains(t, cache.Pages, "page-kept")
+	assert.NotContains(t, cache.Pages, "page-removed")
+}
+
+func TestPruneAcrossQueryPages(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "secret")
+	dir := t.TempDir()
+	page := func(id, title string) string {
+		return `{"object": "page", "id": "` + id + `", "parent": {"type": "database_id", "database_id": "db-1"},
+			"properties": {"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "` + title + `"}}]}}}`
+	}
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": []}`
+		if strings.HasSuffix(req.URL.Path, "/query") {
+			var query notion.DatabaseQuery
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&query))
+			if query.StartCursor == "" {
+				body = `{"object": "list", "has_more": true, "next_cursor": "cursor-2", "results": [` + page("page-1", "Shared") + `]}`
+			} else {
+				body = `{"object": "list", "has_more": false, "results": [` + page("page-2", "Second") + `]}`
+			}
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{
+		Notion: Notion{DatabaseID: "db-1"},
+		Markdown: Markdown{
+			PostSavePath:  filepath.Join(dir, "posts"),
+			ImageSavePath: filepath.Join(dir, "images"),
+		},
+		Prune:     true,
+		CacheFile: filepath.Join(dir, "cache.json"),
+		transport: transport,
+	}
+
+	// page-2 is only on the second page of the query, the deleted page-3 had
+	// the same title as page-1 and shares its image folder
+	sharedImages := filepath.Join(config.ImageSavePath, "Shared")
+	assert.NoError(t, os.MkdirAll(sharedImages, 0755))
+	assert.NoError(t, os.MkdirAll(config.PostSavePath, 0755))
+	for _, name := range []string{"second.md", "orphan.md"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(config.PostSavePath, name), []byte("content"), 0644))
+	}
+	cache := defaultCache()
+	cache.Pages["page-2"] = cacheEntry{OutputPath: "second.md", ImagePath: filepath.Join(config.ImageSavePath, "Second")}
+	cache.Pages["page-3"] = cacheEntry{OutputPath: "orphan.md", ImagePath: sharedImages}
+	assert.NoError(t, saveCache(config.CacheFile, cache))
+
+	assert.NoError(t, Run(config, nil, nil, false))
+	assert.FileExists(t, filepath.Join(config.PostSavePath, "second.md"))
+	assert.FileExists(t, filepath.Join(config.PostSavePath, "shared.md"))
+	assert.NoFileExists(t, filepath.Join(config.PostSavePath, "orphan.md"))
+	assert.DirExists(t, sharedImages)
+	cache, err := loadCache(config.CacheFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, cache.Pages, "page-3")
+}
+
+func TestPublishAfter(t *testing.T) {
+	pages := []string{"page-1", "page-2", "page-3"}
+	var mu sync.Mutex
+	published := make(map[string]int)
+
+	var wg sync.WaitGroup
+	for _, id := range pages {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			_, changed, err := publishAfter(
+				func() (cacheEntry, error) {
+					if id == "page-2" {
+						return cacheEntry{}, errors.New("generation failed")
+					}
+					return cacheEntry{OutputPath: id + ".md"}, nil
+				},
+				func(cacheEntry) error { return nil },
+				func() bool {
+					mu.Lock()
+					defer mu.Unlock()
+					published[id]++
+					return true
+				},
+			)
+			assert.Equal(t, err == nil, changed)
+		}(id)
+	}
+	wg.Wait()
+
+	assert.Equal(t, map[string]int{"page-1": 1, "page-3": 1}, published)
+}
+
+func TestGetPageTitleProperty(t *testing.T) {
+	page := testPage("page-1", "Page Name")
+	page.Properties.(notion.DatabasePageProperties)["Headline"] = notion.DatabasePageProperty{
+		Type:     notion.DBPropTypeRichText,
+		RichText: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "Custom Headline"}}},
+	}
+
+	assert.Equal(t, "Page Name", getPageTitle(page, ""))
+	assert.Equal(t, "Custom Headline", getPageTitle(page, "Headline"))
+	// unknown columns fall back to the heuristic
+	assert.Equal(t, "Page Name", getPageTitle(page, "Missing"))
+
+	config := Markdown{TitleProperty: "Headline"}
+	title := getPageTitle(page, config.TitleProperty)
+	assert.Equal(t, "custom-headline.md", generateArticleFilename(title, time.Time{}, config))
+
+	// other property types are converted to text
+	number := 42.0
+	props := page.Properties.(notion.DatabasePageProperties)
+	props["Issue"] = notion.DatabasePageProperty{Type: notion.DBPropTypeNumber, Number: &number}
+	props["Series"] = notion.DatabasePageProperty{Type: notion.DBPropTypeSelect, Select: &notion.SelectOptions{Name: "Go Tips"}}
+	props["Day"] = notion.DatabasePageProperty{Type: notion.DBPropTypeDate, Date: &notion.Date{
+		Start: notion.NewDateTime(time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), false),
+	}}
+	props["Empty"] = notion.DatabasePageProperty{Type: notion.DBPropTypeNumber}
+	assert.Equal(t, "42", getPageTitle(page, "Issue"))
+	assert.Equal(t, "Go Tips", getPageTitle(page, "Series"))
+	assert.Equal(t, "2024-03-05", getPageTitle(page, "Day"))
+	assert.Equal(t, "2024-03-05.md", generateArticleFilename(getPageTitle(page, "Day"), time.Time{}, config))
+	// empty ones fall back to the heuristic
+	assert.Equal(t, "Page Name", getPageTitle(page, "Empty"))
+}
+
+func TestPreserveTitleFilename(t *testing.T) {
+	config := Markdown{PreserveTitleFilename: true}
+	assert.Equal(t, "My Post.md", generateArticleFilename("My Post", time.Time{}, config))
+	assert.Equal(t, "What_ A_B Story.md", generateArticleFilename("What? A/B Story...", time.Time{}, config))
+	assert.Equal(t, "my-post.md", generateArticleFilename("My Post", time.Time{}, Markdown{}))
+}
+
+func TestTransliterateFilenames(t *testing.T) {
+	// titles are kept in their script by default
+	assert.Equal(t, "привет-мир.md", generateArticleFilename("Привет мир", time.Time{}, Markdown{}))
+
+	config := Markdown{TransliterateFilenames: true}
+	for title, want := range map[string]string{
+		"Привет мир":     "privet-mir.md",
+		"Щука и ёж":      "shchuka-i-ezh.md",
+		"🚀 Launch Day 🎉": "launch-day.md",
+		"Café crème":     "cafe-creme.md",
+		"日本語 notes":      "日本語-notes.md",
+		"Їжак і ґанок":   "yizhak-i-ganok.md",
+	} {
+		assert.Equal(t, want, generateArticleFilename(title, time.Time{}, config), title)
+	}
+
+	// a custom transliteration replaces the built-in one
+	config.Transliterate = func(title string) string { return "custom " + title }
+	assert.Equal(t, "custom-post.md", generateArticleFilename("Post", time.Time{}, config))
+}
+
+func TestPathProperty(t *testing.T) {
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	page := testPage("page-1", "My Post")
+	page.CreatedTime = created
+	page.Properties.(notion.DatabasePageProperties)["Category"] = notion.DatabasePageProperty{
+		Type:   notion.DBPropTypeSelect,
+		Select: &notion.SelectOptions{Name: "Tech/Go News"},
+	}
+	other := testPage("page-2", "Other Post")
+	other.CreatedTime = created
+
+	config := Markdown{PathProperty: "Category", GroupByMonth: true}
+	assert.Equal(t, filepath.Join("tech", "go-news", "my-post.md"), pageFilename(page, "My Post", config))
+	// pages without the property keep the grouped layout
+	assert.Equal(t, filepath.Join("2024-05-01", "other-post.md"), pageFilename(other, "Other Post", config))
+	config.GroupByMonth = false
+	assert.Equal(t, "other-post.md", pageFilename(other, "Other Post", config))
+
+	// the value can't leave PostSavePath
+	page.Properties.(notion.DatabasePageProperties)["Category"] = notion.DatabasePageProperty{
+		Type:     notion.DBPropTypeRichText,
+		RichText: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "../../etc"}}},
+	}
+	assert.Equal(t, filepath.Join("etc", "my-post.md"), pageFilename(page, "My Post", config))
+
+	// the directories are created for the file
+	config.PostSavePath = t.TempDir()
+	outputAbsPath := filepath.Join(config.PostSavePath, pageFilename(page, "My Post", config))
+	assert.NoError(t, generate(nil, page, []notion.Block{testParagraph("Hello")}, config, outputAbsPath, "My Post"))
+	assert.FileExists(t, filepath.Join(config.PostSavePath, "etc", "my-post.md"))
+}
+
+func TestOutputExtension(t *testing.T) {
+	assert.Equal(t, "my-post.mdx", generateArticleFilename("My Post", time.Time{}, Markdown{OutputExtension: ".mdx"}))
+	assert.Equal(t, "my-post.mdx", generateArticleFilename("My Post", time.Time{}, Markdown{OutputExtension: "mdx"}))
+	assert.Equal(t, "My Post.markdown", generateArticleFilename("My Post", time.Time{}, Markdown{OutputExtension: "markdown", PreserveTitleFilename: true}))
+}
+
+func TestPreviewPage(t *testing.T) {
+	responses := map[string]string{
+		"/v1/pages/page-1": `{"object": "page", "id": "page-1",
+			"parent": {"type": "database_id", "database_id": "db-1"},
+			"properties": {"Name": {"type": "title", "title": [{"type": "text", "text": {"content": "Preview"}}]}}}`,
+		"/v1/blocks/page-1/children": `{"object": "list", "has_more": false, "results": [
+			{"object": "block", "id": "block-1", "type": "paragraph",
+			 "paragraph": {"text": [{"type": "text", "text": {"content": "Hello from Notion"}}]}}]}`,
+	}
+	var requested []string
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			requested = append(requested, req.Method+" "+req.URL.Path)
+			body, ok := responses[req.URL.Path]
+			if !ok {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader(`{}`))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+		}),
+	}))
+
+	dir := t.TempDir()
+	config := Markdown{PostSavePath: filepath.Join(dir, "posts"), ImageSavePath: filepath.Join(dir, "images")}
+	var out strings.Builder
+	assert.NoError(t, previewPage(client, "page-1", config, &out))
+
+	assert.Contains(t, out.String(), "Hello from Notion")
+	// read-only: no status update and no files
+	assert.Equal(t, []string{"GET /v1/pages/page-1", "GET /v1/blocks/page-1/children"}, requested)
+	assert.NoDirExists(t, config.PostSavePath)
+	assert.NoDirExists(t, config.ImageSavePath)
+}
+
+func TestUntitledPageFilename(t *testing.T) {
+	page := testPage("0f1e2d3c-0000-4000-8000-000000000001", "")
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	name := resolvePageName(page, Markdown{})
+	assert.Equal(t, "0f1e2d3c-0000-4000-8000-000000000001.md", generateArticleFilename(name, created, Markdown{}))
+
+	config := Markdown{UntitledName: "Untitled", GroupByMonth: true}
+	name = resolvePageName(page, config)
+	assert.Equal(t, filepath.Join("2024-05-01", "untitled-0f1e2d3c-0000-4000-8000-000000000001.md"), generateArticleFilename(name, created, config))
+
+	assert.Equal(t, "untitled.md", generateArticleFilename("...", created, Markdown{PreserveTitleFilename: true}))
+}
+
+func TestGenerateErrorNamesPage(t *testing.T) {
+	page := testPage("page-1", "Hello World")
+	// a directory is in the way of the file
+	outputPath := t.TempDir()
+
+	err := generate(nil, page, nil, Markdown{}, outputPath, "Hello World")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"Hello World"`)
+	assert.Contains(t, err.Error(), "page-1")
+	var pathErr *os.PathError
+	assert.True(t, errors.As(err, &pathErr))
+
+	// rendering errors are wrapped by GenerateTo
+	config := Markdown{Template: filepath.Join(t.TempDir(), "missing.tmpl")}
+	err = generate(nil, page, nil, config, filepath.Join(t.TempDir(), "hello-world.md"), "Hello World")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `page "Hello World" (page-1)`)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+// TestResumeRun interrupts a run by failing the blocks of the second page. The
+// next run picks up the remaining pages without changing the status of the
+// first one again.
+func TestResumeRun(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "secret")
+	dir := t.TempDir()
+	failing := "page-2"
+	var generated, published []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		status, body := http.StatusOK, `{"object": "list", "has_more": false, "results": []}`
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/query"):
+			var results []string
+			for _, id := range []string{"page-1", "page-2", "page-3"} {
+				results = append(results, `{"object": "page", "id": "`+id+`", "parent": {"type": "database_id", "database_id": "db-1"},
+					"last_edited_time": "2024-01-01T10:00:00Z",
+					"properties": {
+						"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "`+id+`"}}]},
+						"Status": {"id": "status", "type": "select", "select": {"name": "Ready"}}}}`)
+			}
+			body = `{"object": "list", "has_more": false, "results": [` + strings.Join(results, ",") + `]}`
+		case req.Method == http.MethodPatch:
+			id := strings.TrimPrefix(req.URL.Path, "/v1/pages/")
+			published = append(published, id)
+			body = `{"object": "page", "id": "` + id + `"}`
+		default:
+			id := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/blocks/"), "/children")
+			if id == failing {
+				status, body = http.StatusBadRequest, `{"object": "error", "status": 400, "code": "validation_error", "message": "failed"}`
+			} else {
+				generated = append(generated, id)
+			}
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{
+		Notion: Notion{DatabaseID: "db-1", FilterProp: "Status", PublishedValue: "Published"},
+		Markdown: Markdown{
+			PostSavePath:  filepath.Join(dir, "posts"),
+			ImageSavePath: filepath.Join(dir, "images"),
+		},
+		Incremental: true,
+		CacheFile:   filepath.Join(dir, "cache.json"),
+		transport:   transport,
+	}
+
+	// the first run stops at the second page, the first one is already committed
+	assert.Error(t, Run(config, nil, nil, false))
+	assert.Equal(t, []string{"page-1"}, generated)
+	assert.Equal(t, []string{"page-1"}, published)
+	cache, err := loadCache(config.CacheFile)
+	assert.NoError(t, err)
+	assert.Contains(t, cache.Pages, "page-1")
+	assert.False(t, cache.Pages["page-1"].StatusPending)
+
+	// the resumed run completes the remaining pages
+	failing = ""
+	generated, published = nil, nil
+	assert.NoError(t, Run(config, nil, nil, false))
+	assert.Equal(t, []string{"page-2", "page-3"}, generated)
+	assert.Equal(t, []string{"page-2", "page-3"}, published)
+
+	// a page generated by a run that stopped before changing its status only
+	// has its status changed
+	cache, err = loadCache(config.CacheFile)
+	assert.NoError(t, err)
+	entry := cache.Pages["page-3"]
+	entry.StatusPending = true
+	cache.Pages["page-3"] = entry
+	assert.NoError(t, saveCache(config.CacheFile, cache))
+	generated, published = nil, nil
+	assert.NoError(t, Run(config, nil, nil, false))
+	assert.Empty(t, generated)
+	assert.Equal(t, []string{"page-3"}, published)
+	cache, err = loadCache(config.CacheFile)
+	assert.NoError(t, err)
+	assert.False(t, cache.Pages["page-3"].StatusPending)
+}
+
+func TestNoStatusUpdate(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "secret")
+	dir := t.TempDir()
+	var updates []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": []}`
+		if strings.HasSuffix(req.URL.Path, "/query") {
+			body = `{"object": "list", "has_more": false, "results": [{"object": "page", "id": "page-1",
+				"parent": {"type": "database_id", "database_id": "db-1"},
+				"properties": {
+					"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "First Post"}}]},
+					"Status": {"id": "status", "type": "select", "select": {"name": "Ready"}}}}]}`
+		} else if req.Method == http.MethodPatch {
+			updates = append(updates, req.URL.Path)
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{
+		Notion: Notion{DatabaseID: "db-1", FilterProp: "Status", PublishedValue: "Published", NoStatusUpdate: true},
+		Markdown: Markdown{
+			PostSavePath:  filepath.Join(dir, "posts"),
+			ImageSavePath: filepath.Join(dir, "images"),
+		},
+		transport: transport,
+	}
+
+	assert.NoError(t, Run(config, nil, nil, false))
+	assert.FileExists(t, filepath.Join(dir, "posts", "first-post.md"))
+	assert.Empty(t, updates)
+
+	// the single-file export leaves the status alone as well
+	config.SingleFile = filepath.Join(dir, "all.md")
+	assert.NoError(t, Run(config, nil, nil, false))
+	assert.FileExists(t, config.SingleFile)
+	assert.Empty(t, updates)
+}
+
+func TestRunWithClient(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "")
+	dir := t.TempDir()
+	var updated []string
+	fake := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": [
+			{"type": "paragraph", "paragraph": {"text": [{"type": "text", "text": {"content": "Hello from the fake"}}]}}]}`
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/query"):
+			body = `{"object": "list", "has_more": false, "results": [{"object": "page", "id": "page-1",
+				"parent": {"type": "database_id", "database_id": "db-1"},
+				"properties": {
+					"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "First Post"}}]},
+					"Status": {"id": "status", "type": "select", "select": {"name": "Ready"}}}}]}`
+		case req.Method == http.MethodPatch:
+			updated = append(updated, req.URL.Path)
+			body = `{"object": "page", "id": "page-1"}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{
+		Notion: Notion{DatabaseID: "db-1", FilterProp: "Status", PublishedValue: "Published"},
+		Markdown: Markdown{
+			PostSavePath:  filepath.Join(dir, "posts"),
+			ImageSavePath: filepath.Join(dir, "images"),
+		},
+		Client: notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: fake})),
+	}
+
+	// no NOTION_SECRET is needed with a client of our own
+	assert.NoError(t, Run(config, nil, nil, false))
+	content, err := os.ReadFile(filepath.Join(dir, "posts", "first-post.md"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "Hello from the fake")
+	assert.Equal(t, []string{"/v1/pages/page-1"}, updated)
+}
+
+func TestImagesSharedAcrossPages(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "secret")
+	dir := t.TempDir()
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var body string
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/query"):
+			var results []string
+			for _, title := range []string{"First", "Second"} {
+				results = append(results, `{"object": "page", "id": "`+title+`", "parent": {"type": "database_id", "database_id": "db-1"},
+					"properties": {"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "`+title+`"}}]}}}`)
+			}
+			body = `{"object": "list", "has_more": false, "results": [` + strings.Join(results, ",") + `]}`
+		case req.URL.Host == "images.example.com":
+			// both pages link the same image at different URLs
+			body = "\x89PNG\r\n\x1a\nsame image"
+		default:
+			id := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/blocks/"), "/children")
+			body = `{"object": "list", "has_more": false, "results": [{"type": "image",
+				"image": {"type": "external", "external": {"url": "https://images.example.com/` + id + `.png"}}}]}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{
+		Notion: Notion{DatabaseID: "db-1"},
+		Markdown: Markdown{
+			PostSavePath:  filepath.Join(dir, "posts"),
+			ImageSavePath: filepath.Join(dir, "images"),
+		},
+		transport: transport,
+	}
+	assert.NoError(t, Run(config, nil, nil, false))
+
+	var images []string
+	assert.NoError(t, filepath.Walk(config.ImageSavePath, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			images = append(images, path)
+		}
+		return err
+	}))
+	assert.Len(t, images, 1)
+
+	// both pages link the file saved by the page rendered first
+	var links []string
+	for _, name := range []string{"first.md", "second.md"} {
+		content, err := os.ReadFile(filepath.Join(config.PostSavePath, name))
+		assert.NoError(t, err)
+		links = append(links, string(content[bytes.Index(content, []byte("![")):]))
+	}
+	assert.Equal(t, links[0], links[1])
+	if len(images) == 1 {
+		assert.Contains(t, links[0], filepath.Base(images[0]))
+	}
+}
+
+func TestZipFile(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "secret")
+	dir := t.TempDir()
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": [
+			{"type": "paragraph", "paragraph": {"text": [{"type": "text", "text": {"content": "Hello"}}]}}]}`
+		if strings.HasSuffix(req.URL.Path, "/query") {
+			body = `{"object": "list", "has_more": false, "results": [{"object": "page", "id": "page-1",
+				"parent": {"type": "database_id", "database_id": "db-1"},
+				"cover": {"type": "external", "external": {"url": "https://images.example.com/cover.png"}},
+				"properties": {"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "First Post"}}]}}}]}`
+		} else if req.URL.Host == "images.example.com" {
+			body = "\x89PNG\r\n\x1a\n"
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{
+		Notion: Notion{DatabaseID: "db-1"},
+		Markdown: Markdown{
+			PostSavePath:  "content/posts",
+			ImageSavePath: "../static/images",
+		},
+		ZipFile:   filepath.Join(dir, "site.zip"),
+		transport: transport,
+	}
+
+	assert.NoError(t, Run(config, nil, nil, false))
+	zr, err := zip.OpenReader(config.ZipFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.ElementsMatch(t, []string{"content/posts/first-post.md", "static/images/First Post/images.example.com__cover.png_cover.png"}, names)
+
+	// nothing is written outside of the archive
+	_, err = os.Stat("content")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSinceCache(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "secret")
+	dir := t.TempDir()
+	edited := map[string]time.Time{
+		"page-1": time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		"page-2": time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC),
+	}
+	var generated []string
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": []}`
+		if strings.HasSuffix(req.URL.Path, "/query") {
+			var results []string
+			for _, id := range []string{"page-1", "page-2"} {
+				results = append(results, `{"object": "page", "id": "`+id+`", "parent": {"type": "database_id", "database_id": "db-1"},
+					"last_edited_time": "`+edited[id].Format(time.RFC3339)+`",
+					"properties": {"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "`+id+`"}}]}}}`)
+			}
+			body = `{"object": "list", "has_more": false, "results": [` + strings.Join(results, ",") + `]}`
+		} else {
+			generated = append(generated, strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/blocks/"), "/children"))
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{
+		Notion: Notion{DatabaseID: "db-1"},
+		Markdown: Markdown{
+			PostSavePath:  filepath.Join(dir, "posts"),
+			ImageSavePath: filepath.Join(dir, "images"),
+		},
+		CacheFile:  filepath.Join(dir, "cache.json"),
+		SinceCache: true,
+		transport:  transport,
+	}
+
+	// the first run has no recorded run and processes every page
+	assert.NoError(t, Run(config, nil, nil, false))
+	assert.ElementsMatch(t, []string{"page-1", "page-2"}, generated)
+
+	// the second run only picks up the page edited since the first
+	generated = nil
+	edited["page-2"] = time.Now().Add(2 * time.Minute)
+	assert.NoError(t, Run(config, nil, nil, false))
+	assert.Equal(t, []string{"page-2"}, generated)
+
+	// an earlier --since wins over the recorded run
+	generated = nil
+	since := time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, Run(config, nil, &since, false))
+	assert.ElementsMatch(t, []string{"page-1", "page-2"}, generated)
+}
@@ -0,0 +1,57 @@
+package generator
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// pageIndex maps the IDs of the exported pages, without dashes, to the pages'
+// titles and output paths relative to markdown.postSavePath. It lets pages
+// link to each other's generated files.
+type pageIndex map[string]indexedPage
+
+type indexedPage struct {
+	Title string
+	Path  string
+}
+
+func newPageIndex(pages []notion.Page, config Markdown) pageIndex {
+	index := make(pageIndex, len(pages))
+	for _, page := range pages {
+		name := resolvePageName(page, config)
+		index[indexKey(page.ID)] = indexedPage{
+			Title: name,
+			Path:  pageFilename(page, name, config),
+		}
+	}
+	return index
+}
+
+func indexKey(pageID string) string {
+	return strings.ReplaceAll(pageID, "-", "")
+}
+
+// linkFrom returns a PageLinkResolver for the page generated at fromPath, it
+// links to the other exported pages relative to that file.
+func (idx pageIndex) linkFrom(fromPath string) func(pageID string) (string, bool) {
+	return func(pageID string) (string, bool) {
+		target, ok := idx[indexKey(pageID)]
+		if !ok {
+			return "", false
+		}
+		rel, err := filepath.Rel(filepath.Dir(fromPath), target.Path)
+		if err != nil {
+			return "", false
+		}
+		return (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath(), true
+	}
+}
+
+// title is the PageTitleResolver of the exported pages
+func (idx pageIndex) title(pageID string) (string, bool) {
+	target, ok := idx[indexKey(pageID)]
+	return target.Title, ok
+}
@@ -0,0 +1,49 @@
+package generator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/stretchr/testify/assert"
+)
+
+// testMention returns a paragraph block mentioning the page with pageID
+func testMention(pageID, text string) notion.Block {
+	href := "https://www.notion.so/" + indexKey(pageID)
+	return notion.Block{
+		Type: notion.BlockTypeParagraph,
+		Paragraph: &notion.RichTextBlock{
+			Text: []notion.RichText{{
+				Type:      notion.RichTextTypeMention,
+				PlainText: text,
+				HRef:      &href,
+				Mention:   &notion.Mention{Type: notion.MentionTypePage, Page: &notion.ID{ID: pageID}},
+			}},
+		},
+	}
+}
+
+func TestCrossLinkedPages(t *testing.T) {
+	first := testPage("1a2b3c4d-0000-4000-8000-000000000001", "First Post")
+	first.CreatedTime = time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	second := testPage("1a2b3c4d-0000-4000-8000-000000000002", "Second Post")
+	second.CreatedTime = time.Date(2024, 2, 10, 0, 0, 0, 0, time.UTC)
+
+	config := Markdown{ImageSavePath: t.TempDir(), GroupByMonth: true}
+	config.pages = newPageIndex([]notion.Page{first, second}, config)
+
+	render := func(page notion.Page, blocks ...notion.Block) string {
+		out, err := renderPage(nil, page, blocks, config, resolvePageName(page, config))
+		assert.NoError(t, err)
+		return out.String()
+	}
+
+	assert.Contains(t, render(first, testMention(second.ID, "Second Post")), "[Second Post](../2024-02-10/second-post.md)")
+	assert.Contains(t, render(second, testMention(first.ID, "First Post")), "[First Post](../2024-01-10/first-post.md)")
+
+	// pages outside of the export keep their Notion link
+	unknown := "1a2b3c4d-0000-4000-8000-000000000003"
+	assert.Contains(t, render(first, testMention(unknown, "Elsewhere")),
+		"[Elsewhere](https://www.notion.so/1a2b3c4d000040008000000000000003)")
+}
@@ -0,0 +1,162 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported values for Config.LogFormat.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+const progressBarWidth = 30
+
+// Page statuses reported by the logger.
+const (
+	pageStatusGenerated = "generated"
+	pageStatusSkipped   = "skipped"
+	pageStatusFailed    = "failed"
+)
+
+// runLogger writes the output of a run. In text mode it prints human readable
+// lines, optionally replacing the detailed per-page lines with a progress bar
+// redrawn in place. In JSON mode every message and page result is written as
+// one JSON object per line.
+type runLogger struct {
+	mu       sync.Mutex
+	out      io.Writer
+	json     bool
+	progress bool
+	total    int
+	done     int
+}
+
+// pageLogEntry is the JSON line written for each processed page
+type pageLogEntry struct {
+	PageID     string `json:"page_id"`
+	Title      string `json:"title"`
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newRunLogger(out io.Writer, progress bool, format string) *runLogger {
+	isJSON := format == LogFormatJSON
+	return &runLogger{out: out, json: isJSON, progress: progress && !isJSON}
+}
+
+// quiet reports whether the logger output must not be mixed with other output,
+// like the spinner
+func (l *runLogger) quiet() bool {
+	return l.progress || l.json
+}
+
+// start resets the progress for a run over total pages
+func (l *runLogger) start(total int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total = total
+	l.done = 0
+	if l.progress {
+		l.drawBar()
+	}
+}
+
+// infof prints a general message about the run
+func (l *runLogger) infof(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json {
+		message := strings.TrimSpace(fmt.Sprintf(format, args...))
+		l.writeJSON(map[string]string{"message": message})
+		return
+	}
+	fmt.Fprintf(l.out, format, args...)
+}
+
+// warn prints a problem that doesn't fail the run. A progress bar is cleared
+// for it and drawn again below.
+func (l *runLogger) warn(message string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case l.json:
+		l.writeJSON(map[string]string{"warning": message})
+	case l.progress:
+		fmt.Fprintf(l.out, "\r%-*s\n", progressBarWidth+20, "⚠ "+message)
+		l.drawBar()
+	default:
+		fmt.Fprintf(l.out, "⚠ %s\n", message)
+	}
+}
+
+// pagef prints a detailed per-page line; it is suppressed in progress and JSON mode
+func (l *runLogger) pagef(format string, args ...interface{}) {
+	if l.quiet() {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, format, args...)
+}
+
+// pageResult reports the outcome of a page; only JSON mode writes it out
+func (l *runLogger) pageResult(pageID, title, status string, started time.Time, err error) {
+	if !l.json {
+		return
+	}
+	entry := pageLogEntry{
+		PageID:     pageID,
+		Title:      title,
+		Status:     status,
+		DurationMS: time.Since(started).Milliseconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writeJSON(entry)
+}
+
+// pageDone records a finished page and advances the progress bar
+func (l *runLogger) pageDone() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done++
+	if l.progress {
+		l.drawBar()
+	}
+}
+
+// finish terminates the progress bar line
+func (l *runLogger) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.progress {
+		fmt.Fprintln(l.out)
+	}
+}
+
+func (l *runLogger) drawBar() {
+	filled := 0
+	if l.total > 0 {
+		filled = l.done * progressBarWidth / l.total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	fmt.Fprintf(l.out, "\r[%s] %d/%d pages", bar, l.done, l.total)
+}
+
+func (l *runLogger) writeJSON(v interface{}) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	l.out.Write(append(line, '\n'))
+}
@@ -0,0 +1,116 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunLoggerProgressMode(t *testing.T) {
+	var out bytes.Buffer
+	logger := newRunLogger(&out, true, LogFormatText)
+	logger.start(2)
+	for _, name := range []string{"1:First", "2:Second"} {
+		logger.pagef("[%-30s] ✔ generating blog post: completed\n", name)
+		logger.pageDone()
+	}
+	logger.finish()
+
+	assert.NotContains(t, out.String(), "completed")
+	assert.Contains(t, out.String(), "2/2 pages")
+}
+
+func TestRunLoggerVerboseMode(t *testing.T) {
+	var out bytes.Buffer
+	logger := newRunLogger(&out, false, LogFormatText)
+	logger.start(1)
+	logger.pagef("[%-30s] ✔ generating blog post: completed\n", "1:First")
+	logger.pageDone()
+	logger.finish()
+
+	assert.Contains(t, out.String(), "completed")
+	assert.NotContains(t, out.String(), "pages")
+}
+
+// TestRunLoggerConcurrentLines logs from parallel workers like the parallel
+// mode of Run and checks that no line is interleaved with another
+func TestRunLoggerConcurrentLines(t *testing.T) {
+	var out bytes.Buffer
+	logger := newRunLogger(&out, false, LogFormatText)
+	logger.start(20)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("%d:%s", i, strings.Repeat("x", 40))
+			for j := 0; j < 10; j++ {
+				logger.pagef("[%-30s] ✔ getting blocks tree: completed\n", name)
+				logger.infof("page %d step %d\n", i, j)
+			}
+			logger.pageDone()
+		}(i)
+	}
+	wg.Wait()
+	logger.finish()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 400)
+	for _, line := range lines {
+		if strings.HasPrefix(line, "page ") {
+			var i, j int
+			n, err := fmt.Sscanf(line, "page %d step %d", &i, &j)
+			assert.True(t, err == nil && n == 2, line)
+			continue
+		}
+		assert.True(t, strings.HasPrefix(line, "[") && strings.HasSuffix(line, "] ✔ getting blocks tree: completed"), line)
+	}
+}
+
+func TestRunLoggerWarnProgressMode(t *testing.T) {
+	var out bytes.Buffer
+	logger := newRunLogger(&out, true, LogFormatText)
+	logger.start(2)
+	logger.warn("skipping image https://img.example.com/missing.png: 404 Not Found")
+	logger.pageDone()
+	logger.finish()
+
+	// the warning replaces the bar on its line and the bar is drawn again below
+	lines := strings.Split(out.String(), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "\r[      "), lines[0])
+	assert.Contains(t, lines[0], "\r⚠ skipping image https://img.example.com/missing.png: 404 Not Found")
+	assert.True(t, strings.HasSuffix(lines[1], "1/2 pages"), lines[1])
+}
+
+func TestRunLoggerJSONMode(t *testing.T) {
+	var out bytes.Buffer
+	logger := newRunLogger(&out, true, LogFormatJSON)
+	logger.start(2)
+	logger.infof("✔ Querying Notion database: Completed\n")
+	logger.pagef("[%-30s] ✔ generating blog post: completed\n", "1:First")
+	logger.pageResult("page-1", "First", pageStatusGenerated, time.Now(), nil)
+	logger.pageResult("page-2", "Second", pageStatusFailed, time.Now(), errors.New("boom"))
+	logger.pageDone()
+	logger.finish()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 3)
+	for _, line := range lines {
+		assert.True(t, json.Valid([]byte(line)), line)
+	}
+
+	var entry pageLogEntry
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &entry))
+	assert.Equal(t, "page-2", entry.PageID)
+	assert.Equal(t, "Second", entry.Title)
+	assert.Equal(t, pageStatusFailed, entry.Status)
+	assert.Equal(t, "boom", entry.Error)
+}
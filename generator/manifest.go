@@ -0,0 +1,71 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// manifestEntry describes one exported page in the manifest file.
+type manifestEntry struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	LastEdited string `json:"last_edited"`
+	// Path of the generated file relative to markdown.postSavePath, with "/" separators
+	Path string `json:"path"`
+}
+
+// manifest collects the pages of a run, it is safe for concurrent use.
+type manifest struct {
+	mu    sync.Mutex
+	pages []manifestEntry
+}
+
+func (m *manifest) add(page notion.Page, title, outputRelPath string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pages = append(m.pages, manifestEntry{
+		ID:         page.ID,
+		Title:      title,
+		LastEdited: cacheTimestamp(page.LastEditedTime),
+		Path:       filepath.ToSlash(outputRelPath),
+	})
+}
+
+// write saves the manifest as {"pages": [...]} sorted by page ID.
+func (m *manifest) write(path string) error {
+	m.mu.Lock()
+	pages := append([]manifestEntry{}, m.pages...)
+	m.mu.Unlock()
+	sort.Slice(pages, func(i, j int) bool { return pages[i].ID < pages[j].ID })
+
+	content, err := json.MarshalIndent(struct {
+		Pages []manifestEntry `json:"pages"`
+	}{pages}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// writeManifest saves the manifest of a run when manifestFile is configured.
+func writeManifest(m *manifest, path string, logger *runLogger) error {
+	if path == "" {
+		return nil
+	}
+	if err := m.write(path); err != nil {
+		return fmt.Errorf("failed writing manifest %q: %w", path, err)
+	}
+	logger.infof("✔ Manifest written: %s\n", path)
+	return nil
+}
@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestWrite(t *testing.T) {
+	edited := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	first := testPage("page-b", "Second Post")
+	first.LastEditedTime = edited
+	second := testPage("page-a", "First Post")
+	second.LastEditedTime = edited
+
+	m := &manifest{}
+	m.add(first, "Second Post", filepath.Join("2024-02-03", "second-post.md"))
+	m.add(second, "First Post", "first-post.md")
+
+	path := filepath.Join(t.TempDir(), "out", "manifest.json")
+	assert.NoError(t, writeManifest(m, path, newRunLogger(io.Discard, false, LogFormatText)))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var written struct {
+		Pages []manifestEntry `json:"pages"`
+	}
+	assert.NoError(t, json.Unmarshal(content, &written))
+	assert.Equal(t, []manifestEntry{
+		{ID: "page-a", Title: "First Post", LastEdited: "2024-02-03T04:05:06Z", Path: "first-post.md"},
+		{ID: "page-b", Title: "Second Post", LastEdited: "2024-02-03T04:05:06Z", Path: "2024-02-03/second-post.md"},
+	}, written.Pages)
+}
+
+func TestManifestDisabled(t *testing.T) {
+	m := &manifest{}
+	m.add(testPage("page-a", "First Post"), "First Post", "first-post.md")
+	assert.NoError(t, writeManifest(m, "", newRunLogger(io.Discard, false, LogFormatText)))
+}
@@ -1,16 +1,257 @@
 package generator
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/bonaysoft/notion-md-gen/pkg/tomarkdown"
+
 	"github.com/briandowns/spinner"
 	"github.com/dstotijn/go-notion"
+	"github.com/hashicorp/go-retryablehttp"
 )
 
 var spin = spinner.New(spinner.CharSets[14], time.Millisecond*100)
 
+// errMissingSecret is returned when no Notion integration token is configured
+var errMissingSecret = errors.New("NOTION_SECRET is not set: export it or add NOTION_SECRET=<integration token> to the .env file")
+
+// NotionAPI is the part of the Notion API the generator uses. *notion.Client
+// implements it, other implementations can serve pages from elsewhere or mock
+// the API in tests.
+type NotionAPI interface {
+	QueryDatabase(ctx context.Context, id string, query *notion.DatabaseQuery) (notion.DatabaseQueryResponse, error)
+	FindBlockChildrenByID(ctx context.Context, blockID string, query *notion.PaginationQuery) (notion.BlockChildrenResponse, error)
+	UpdatePage(ctx context.Context, pageID string, params notion.UpdatePageParams) (notion.Page, error)
+	FindPageByID(ctx context.Context, id string) (notion.Page, error)
+	FindUserByID(ctx context.Context, id string) (notion.User, error)
+}
+
+// newClient returns config.Client when set, or a Notion client that retries
+// failed requests and paces all API calls according to config.RequestsPerSecond.
+func newClient(config Config) (NotionAPI, error) {
+	if config.Client != nil {
+		return config.Client, nil
+	}
+	secret := os.Getenv("NOTION_SECRET")
+	if strings.TrimSpace(secret) == "" {
+		return nil, errMissingSecret
+	}
+	retryClient := retryablehttp.NewClient()
+	if config.transport != nil {
+		retryClient.HTTPClient.Transport = config.transport
+	}
+	retryClient.HTTPClient.Transport = wrapTransport(retryClient.HTTPClient.Transport, config)
+	return notion.NewClient(secret, notion.WithHTTPClient(retryClient.StandardClient())), nil
+}
+
+// imageRetryWaitMin is the first backoff between image download attempts
+var imageRetryWaitMin = time.Second
+
+// newImageClient returns a client for image downloads that retries connection
+// errors, 429 and 5xx responses up to retries times (default 3). base is the
+// transport to use, nil for the default one.
+func newImageClient(retries int, base http.RoundTripper) *http.Client {
+	retryClient := retryablehttp.NewClient()
+	if retries == 0 {
+		retries = 3
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	retryClient.RetryMax = retries
+	retryClient.RetryWaitMin = imageRetryWaitMin
+	retryClient.HTTPClient.Timeout = time.Minute
+	if base != nil {
+		retryClient.HTTPClient.Transport = base
+	}
+	return retryClient.StandardClient()
+}
+
+// DefaultImageConcurrency is the number of images downloaded at the same time
+// when Markdown.ImageConcurrency is not set.
+const DefaultImageConcurrency = 8
+
+// newDownloadLimiter returns the image download limiter shared by all pages,
+// nil when the limit is disabled.
+func newDownloadLimiter(config Markdown) *tomarkdown.DownloadLimiter {
+	if config.ImageConcurrency == 0 {
+		return tomarkdown.NewDownloadLimiter(DefaultImageConcurrency)
+	}
+	return tomarkdown.NewDownloadLimiter(config.ImageConcurrency)
+}
+
+// wrapTransport adds the rate limiting, extra headers, API version override and
+// status property translation of config to base.
+func wrapTransport(base http.RoundTripper, config Config) http.RoundTripper {
+	if config.AdaptiveParallelism && config.workers != nil {
+		base = &throttleTransport{base: base, workers: config.workers}
+	}
+	if limiter := newRateLimiter(config.RequestsPerSecond); limiter != nil {
+		base = &rateLimitedTransport{base: base, limiter: limiter}
+	}
+	headers := make(map[string]string, len(config.Headers)+1)
+	for name, value := range config.Headers {
+		headers[name] = value
+	}
+	if config.APIVersion != "" {
+		headers["Notion-Version"] = config.APIVersion
+	}
+	if len(headers) > 0 {
+		base = &headerTransport{base: base, headers: headers}
+	}
+	if config.columnWidths != nil {
+		base = &recordTransport{base: base, record: config.columnWidths.record}
+	}
+	if config.blockColors != nil {
+		base = &recordTransport{base: base, record: config.blockColors.record}
+	}
+	if config.editors != nil {
+		base = &recordTransport{base: base, record: config.editors.record}
+	}
+	return newStatusTransport(base)
+}
+
+// columnWidths keeps the width_ratio of the columns seen in block children
+// responses by column ID. go-notion doesn't decode the field. It is safe for
+// concurrent use.
+type columnWidths struct {
+	mu     sync.Mutex
+	ratios map[string]float64
+}
+
+func newColumnWidths() *columnWidths {
+	return &columnWidths{ratios: make(map[string]float64)}
+}
+
+// ratio is the ToMarkdown.ColumnWidthRatio of the recorded columns
+func (c *columnWidths) ratio(columnID string) (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ratio, ok := c.ratios[columnID]
+	return ratio, ok
+}
+
+// record stores the column widths of a block children response body
+func (c *columnWidths) record(req *http.Request, body []byte) {
+	if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/children") {
+		return
+	}
+	var list struct {
+		Results []struct {
+			ID     string `json:"id"`
+			Column *struct {
+				WidthRatio float64 `json:"width_ratio"`
+			} `json:"column"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, block := range list.Results {
+		if block.Column != nil && block.Column.WidthRatio > 0 {
+			c.ratios[block.ID] = block.Column.WidthRatio
+		}
+	}
+}
+
+// blockColors keeps the color of the callouts and quotes seen in block
+// children responses by block ID. go-notion doesn't decode the field. It is
+// safe for concurrent use.
+type blockColors struct {
+	mu     sync.Mutex
+	colors map[string]string
+}
+
+func newBlockColors() *blockColors {
+	return &blockColors{colors: make(map[string]string)}
+}
+
+// color is the ToMarkdown.BlockColor of the recorded blocks
+func (c *blockColors) color(blockID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	color, ok := c.colors[blockID]
+	return color, ok
+}
+
+// record stores the callout and quote colors of a block children response body
+func (c *blockColors) record(req *http.Request, body []byte) {
+	if req.Method != http.MethodGet || !strings.HasSuffix(req.URL.Path, "/children") {
+		return
+	}
+	type colored struct {
+		Color string `json:"color"`
+	}
+	var list struct {
+		Results []struct {
+			ID      string   `json:"id"`
+			Callout *colored `json:"callout"`
+			Quote   *colored `json:"quote"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, block := range list.Results {
+		if block.Callout != nil && block.Callout.Color != "" {
+			c.colors[block.ID] = block.Callout.Color
+		}
+		if block.Quote != nil && block.Quote.Color != "" {
+			c.colors[block.ID] = block.Quote.Color
+		}
+	}
+}
+
+// recordTransport passes the body of successful responses to record, to read
+// fields go-notion doesn't decode
+type recordTransport struct {
+	base   http.RoundTripper
+	record func(req *http.Request, body []byte)
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	t.record(req, body)
+	return resp, nil
+}
+
+// headerTransport sets headers on every request, replacing those set by
+// go-notion, like the pinned Notion-Version
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
 func filterFromConfig(config Notion) *notion.DatabaseQueryFilter {
 	if config.FilterProp == "" || len(config.FilterValue) == 0 {
 		return nil
@@ -31,26 +272,84 @@ func filterFromConfig(config Notion) *notion.DatabaseQueryFilter {
 	}
 }
 
-func queryDatabase(client *notion.Client, config Notion) (notion.DatabaseQueryResponse, error) {
+func sortsFromConfig(config Notion) []notion.DatabaseQuerySort {
+	if len(config.Sorts) == 0 {
+		return nil
+	}
+
+	sorts := make([]notion.DatabaseQuerySort, len(config.Sorts))
+	for i, sort := range config.Sorts {
+		direction := notion.SortDirection(sort.Direction)
+		if direction == "" {
+			direction = notion.SortDirAsc
+		}
+		sorts[i] = notion.DatabaseQuerySort{
+			Property:  sort.Property,
+			Timestamp: notion.SortTimestamp(sort.Timestamp),
+			Direction: direction,
+		}
+	}
+	return sorts
+}
+
+func queryDatabase(client NotionAPI, config Notion) (notion.DatabaseQueryResponse, error) {
 	spin.Suffix = " Querying Notion database..."
 	spin.Start()
 	defer spin.Stop()
 
 	query := &notion.DatabaseQuery{
 		Filter:   filterFromConfig(config),
+		Sorts:    sortsFromConfig(config),
 		PageSize: 100,
 	}
-	return client.QueryDatabase(context.Background(), config.DatabaseID, query)
+	// follow the cursor, pruning relies on the complete list of pages
+	var result notion.DatabaseQueryResponse
+	for {
+		resp, err := client.QueryDatabase(context.Background(), config.DatabaseID, query)
+		if err != nil {
+			return result, err
+		}
+		result.Results = append(result.Results, resp.Results...)
+		if !resp.HasMore || resp.NextCursor == nil {
+			return result, nil
+		}
+		query.StartCursor = *resp.NextCursor
+	}
 }
 
-func queryBlockChildren(client *notion.Client, blockID string) (blocks []notion.Block, err error) {
+// queryDatabasePages returns the first limit pages of a database with their
+// titles, for the pages listed below child databases.
+func queryDatabasePages(client NotionAPI, databaseID string, limit int, config Markdown) ([]tomarkdown.DatabasePage, error) {
+	var pages []tomarkdown.DatabasePage
+	query := &notion.DatabaseQuery{}
+	for len(pages) < limit {
+		query.PageSize = limit - len(pages)
+		if query.PageSize > 100 {
+			query.PageSize = 100
+		}
+		resp, err := client.QueryDatabase(context.Background(), databaseID, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range resp.Results {
+			pages = append(pages, tomarkdown.DatabasePage{ID: page.ID, Title: getPageTitle(page, config.TitleProperty)})
+		}
+		if !resp.HasMore || resp.NextCursor == nil {
+			break
+		}
+		query.StartCursor = *resp.NextCursor
+	}
+	return pages, nil
+}
+
+func queryBlockChildren(client NotionAPI, blockID string, maxDepth int) (blocks []notion.Block, err error) {
 	spin.Suffix = " Fetching blocks tree..."
 	spin.Start()
 	defer spin.Stop()
-	return retrieveBlockChildren(client, blockID)
+	return retrieveBlockChildren(client, blockID, maxDepth)
 }
 
-func retrieveBlockChildrenLoop(client *notion.Client, blockID, cursor string) (blocks []notion.Block, err error) {
+func retrieveBlockChildrenLoop(client NotionAPI, blockID, cursor string) (blocks []notion.Block, err error) {
 	for {
 		query := &notion.PaginationQuery{
 			StartCursor: cursor,
@@ -73,9 +372,23 @@ func retrieveBlockChildrenLoop(client *notion.Client, blockID, cursor string) (b
 	}
 }
 
-func retrieveBlockChildren(client *notion.Client, blockID string) (blocks []notion.Block, err error) {
+// DefaultMaxBlockDepth is the number of nested block levels fetched when
+// Markdown.MaxBlockDepth is not set.
+const DefaultMaxBlockDepth = 16
+
+// blockDepth returns the configured number of block levels to fetch
+func blockDepth(config Markdown) int {
+	if config.MaxBlockDepth > 0 {
+		return config.MaxBlockDepth
+	}
+	return DefaultMaxBlockDepth
+}
+
+// retrieveBlockChildren fetches the children of a block and, recursively, their
+// children up to maxDepth levels. Deeper blocks are left without children.
+func retrieveBlockChildren(client NotionAPI, blockID string, maxDepth int) (blocks []notion.Block, err error) {
 	blocks, err = retrieveBlockChildrenLoop(client, blockID, "")
-	if err != nil {
+	if err != nil || maxDepth <= 1 {
 		return
 	}
 
@@ -86,17 +399,27 @@ func retrieveBlockChildren(client *notion.Client, blockID string) (blocks []noti
 
 		switch block.Type {
 		case notion.BlockTypeParagraph:
-			block.Paragraph.Children, err = retrieveBlockChildren(client, block.ID)
+			block.Paragraph.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
 		case notion.BlockTypeCallout:
-			block.Callout.Children, err = retrieveBlockChildren(client, block.ID)
+			block.Callout.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
 		case notion.BlockTypeQuote:
-			block.Quote.Children, err = retrieveBlockChildren(client, block.ID)
+			block.Quote.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
 		case notion.BlockTypeBulletedListItem:
-			block.BulletedListItem.Children, err = retrieveBlockChildren(client, block.ID)
+			block.BulletedListItem.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
 		case notion.BlockTypeNumberedListItem:
-			block.NumberedListItem.Children, err = retrieveBlockChildren(client, block.ID)
+			block.NumberedListItem.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
+		case notion.BlockTypeToDo:
+			block.ToDo.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
+		case notion.BlockTypeToggle:
+			block.Toggle.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
+		case notion.BlockTypeColumnList:
+			block.ColumnList.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
+		case notion.BlockTypeColumn:
+			block.Column.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
 		case notion.BlockTypeTable:
-			block.Table.Children, err = retrieveBlockChildren(client, block.ID)
+			block.Table.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
+		case notion.BlockTypeSyncedBlock:
+			block.SyncedBlock.Children, err = retrieveBlockChildren(client, block.ID, maxDepth-1)
 		}
 
 		if err != nil {
@@ -108,14 +431,21 @@ func retrieveBlockChildren(client *notion.Client, blockID string) (blocks []noti
 }
 
 // changeStatus changes the Notion article status to the published value if set.
-// It returns true if status changed.
-func changeStatus(client *notion.Client, p notion.Page, config Notion) bool {
-	// No published value or filter prop to change
-	if config.FilterProp == "" || config.PublishedValue == "" {
+// The filter property may be a select or a status property. It returns true if
+// status changed.
+func changeStatus(client NotionAPI, p notion.Page, config Notion, logger *runLogger) bool {
+	// No published value or filter prop to change, or no changes wanted
+	if config.FilterProp == "" || config.PublishedValue == "" || config.NoStatusUpdate {
 		return false
 	}
 
-	if v, ok := p.Properties.(notion.DatabasePageProperties)[config.FilterProp]; ok {
+	props, _ := p.Properties.(notion.DatabasePageProperties)
+	if v, ok := props[config.FilterProp]; ok {
+		if (v.Type != notion.DBPropTypeSelect && v.Type != tomarkdown.DBPropTypeStatus) || v.Select == nil {
+			// status options are only read through the statusTransport
+			logger.infof("can't change status: property %q is of type %q, only select and status properties are supported\n", config.FilterProp, v.Type)
+			return false
+		}
 		if v.Select.Name == config.PublishedValue {
 			return false
 		}
@@ -136,7 +466,7 @@ func changeStatus(client *notion.Client, p notion.Page, config Notion) bool {
 		},
 	)
 	if err != nil {
-		log.Println("error changing status:", err)
+		logger.infof("error changing status: %v\n", err)
 	}
 
 	return err == nil
@@ -0,0 +1,426 @@
+package generator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bonaysoft/notion-md-gen/pkg/tomarkdown"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIVersionHeader(t *testing.T) {
+	var versions []string
+	recorder := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		versions = append(versions, req.Header.Get("Notion-Version"))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"object": "page", "id": "page-1", "parent": {"type": "workspace"}, "properties": {}}`)),
+		}, nil
+	})
+
+	config := Config{Notion: Notion{APIVersion: "2022-06-28"}}
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: wrapTransport(recorder, config)}))
+	_, err := client.FindPageByID(context.Background(), "page-1")
+	assert.NoError(t, err)
+
+	// without an override the library default is kept
+	client = notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: wrapTransport(recorder, Config{})}))
+	_, err = client.FindPageByID(context.Background(), "page-1")
+	assert.NoError(t, err)
+
+	assert.Len(t, versions, 2)
+	assert.Equal(t, "2022-06-28", versions[0])
+	assert.NotEmpty(t, versions[1])
+	assert.NotEqual(t, "2022-06-28", versions[1])
+}
+
+func TestCustomHeaders(t *testing.T) {
+	var requests []*http.Request
+	recorder := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"object": "page", "id": "page-1", "parent": {"type": "workspace"}, "properties": {}}`)),
+		}, nil
+	})
+
+	config := Config{Notion: Notion{Headers: map[string]string{"X-Proxy-Token": "s3cret", "Notion-Version": "2021-05-13"}, APIVersion: "2022-06-28"}}
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: wrapTransport(recorder, config)}))
+	_, err := client.FindPageByID(context.Background(), "page-1")
+	assert.NoError(t, err)
+	_, err = client.FindPageByID(context.Background(), "page-1")
+	assert.NoError(t, err)
+
+	assert.Len(t, requests, 2)
+	for _, req := range requests {
+		assert.Equal(t, "s3cret", req.Header.Get("X-Proxy-Token"))
+		// apiVersion takes precedence over the headers
+		assert.Equal(t, "2022-06-28", req.Header.Get("Notion-Version"))
+		assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+	}
+}
+
+func TestImageClientRetries(t *testing.T) {
+	imageRetryWaitMin = time.Millisecond
+	defer func() { imageRetryWaitMin = time.Second }()
+
+	statuses := map[string][]int{
+		"/flaky.png":   {http.StatusInternalServerError, http.StatusOK},
+		"/missing.png": {http.StatusNotFound, http.StatusOK},
+	}
+	attempts := make(map[string]int)
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		status := statuses[req.URL.Path][attempts[req.URL.Path]]
+		attempts[req.URL.Path]++
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader("image bytes")),
+			Header:     make(http.Header),
+		}, nil
+	})
+
+	var log strings.Builder
+	config := Markdown{ImageSavePath: t.TempDir(), ImagePublicLink: "/images"}
+	config.logger = newRunLogger(&log, false, LogFormatJSON)
+	tm := newToMarkdown(nil, config, "post")
+	tm.ImageClient = newImageClient(config.ImageRetries, transport)
+	blocks := []notion.Block{
+		{Type: notion.BlockTypeImage, Image: &notion.FileBlock{Type: notion.FileTypeExternal, External: &notion.FileExternal{URL: "https://img.example.com/flaky.png"}}},
+		{Type: notion.BlockTypeImage, Image: &notion.FileBlock{Type: notion.FileTypeExternal, External: &notion.FileExternal{URL: "https://img.example.com/missing.png"}}},
+	}
+	var out strings.Builder
+	assert.NoError(t, tm.GenerateTo(blocks, &out))
+
+	// the 500 is retried, the 404 is not and the image keeps its remote URL
+	assert.Equal(t, map[string]int{"/flaky.png": 2, "/missing.png": 1}, attempts)
+	files, err := os.ReadDir(filepath.Join(config.ImageSavePath, "post"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Contains(t, out.String(), "https://img.example.com/missing.png")
+	assert.Equal(t, `{"warning":"skipping image https://img.example.com/missing.png: Not Found"}`+"\n", log.String())
+}
+
+// TestChangeStatusRawClientSkipsStatusProperty changes the status with a
+// client lacking the statusTransport, e.g. Config.Client, which never sees
+// the option of a status property.
+func TestChangeStatusRawClientSkipsStatusProperty(t *testing.T) {
+	var page notion.Page
+	assert.NoError(t, json.Unmarshal([]byte(`{
+		"id": "page-1",
+		"parent": {"type": "database_id", "database_id": "db-1"},
+		"properties": {"Status": {"id": "a", "type": "status", "status": {"name": "Finished"}}}
+	}`), &page))
+
+	requests := 0
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			requests++
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("{}")), Request: req}, nil
+		}),
+	}))
+	changed := changeStatus(client, page, Notion{FilterProp: "Status", PublishedValue: "Published"}, newRunLogger(io.Discard, false, LogFormatText))
+	assert.False(t, changed)
+	assert.Equal(t, 0, requests)
+}
+
+func TestChangeStatusThroughStatusTransport(t *testing.T) {
+	db := &statusDatabase{propType: "status"}
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: newStatusTransport(db)}))
+	q, err := client.QueryDatabase(context.Background(), "db-1", &notion.DatabaseQuery{})
+	assert.NoError(t, err)
+	if !assert.Len(t, q.Results, 1) {
+		return
+	}
+
+	changed := changeStatus(client, q.Results[0], Notion{FilterProp: "Status", PublishedValue: "Published"}, newRunLogger(io.Discard, false, LogFormatText))
+	assert.True(t, changed)
+	if assert.Len(t, db.updates, 1) {
+		published := map[string]interface{}{"status": map[string]interface{}{"name": "Published"}}
+		assert.Equal(t, map[string]interface{}{"Status": published}, db.updates[0]["properties"])
+	}
+}
+
+func TestQueryDatabaseSorts(t *testing.T) {
+	var query notion.DatabaseQuery
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "/v1/databases/db-1/query", req.URL.Path)
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&query))
+			body := `{"object": "list", "has_more": false, "results": [
+				{"object": "page", "id": "page-new", "parent": {"type": "database_id", "database_id": "db-1"}, "properties": {}},
+				{"object": "page", "id": "page-old", "parent": {"type": "database_id", "database_id": "db-1"}, "properties": {}}]}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+		}),
+	}))
+
+	config := Notion{
+		DatabaseID: "db-1",
+		Sorts: []Sort{
+			{Property: "Date", Direction: "descending"},
+			{Timestamp: "created_time"},
+		},
+	}
+	res, err := queryDatabase(client, config)
+	assert.NoError(t, err)
+	assert.Len(t, res.Results, 2)
+	assert.Equal(t, []notion.DatabaseQuerySort{
+		{Property: "Date", Direction: notion.SortDirDesc},
+		{Timestamp: notion.SortTimeStampCreatedTime, Direction: notion.SortDirAsc},
+	}, query.Sorts)
+	assert.Equal(t, "page-new", res.Results[0].ID)
+	assert.Equal(t, "page-old", res.Results[1].ID)
+}
+
+func TestRetrieveBlockChildrenDepth(t *testing.T) {
+	children := map[string]string{
+		"page-1": `{"object": "block", "id": "toggle-1", "type": "toggle", "has_children": true,
+			"toggle": {"text": [{"type": "text", "text": {"content": "Details"}}]}}`,
+		"toggle-1": `{"object": "block", "id": "column-list-1", "type": "column_list", "has_children": true, "column_list": {}}`,
+		"column-list-1": `{"object": "block", "id": "paragraph-1", "type": "paragraph",
+			"paragraph": {"text": [{"type": "text", "text": {"content": "Deep"}}]}}`,
+	}
+	var requested []string
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			blockID := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/blocks/"), "/children")
+			requested = append(requested, blockID)
+			body := `{"object": "list", "has_more": false, "results": [` + children[blockID] + `]}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+		}),
+	}))
+
+	blocks, err := retrieveBlockChildren(client, "page-1", DefaultMaxBlockDepth)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"page-1", "toggle-1", "column-list-1"}, requested)
+	columnList := blocks[0].Toggle.Children[0].ColumnList
+	assert.Equal(t, "Deep", columnList.Children[0].Paragraph.Text[0].Text.Content)
+
+	// the column list is the second level and its children are not fetched
+	requested = nil
+	blocks, err = retrieveBlockChildren(client, "page-1", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"page-1", "toggle-1"}, requested)
+	assert.Empty(t, blocks[0].Toggle.Children[0].ColumnList.Children)
+}
+
+func TestColumnWidths(t *testing.T) {
+	response := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": [
+			{"object": "block", "id": "column-1", "type": "column", "column": {"width_ratio": 0.3}},
+			{"object": "block", "id": "column-2", "type": "column", "column": {"width_ratio": 0.7}},
+			{"object": "block", "id": "column-3", "type": "column", "column": {}}]}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{Markdown: Markdown{columnWidths: newColumnWidths()}}
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: wrapTransport(response, config)}))
+
+	blocks, err := retrieveBlockChildren(client, "column-list-1", 1)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 3)
+
+	tm := newToMarkdown(nil, config.Markdown, "post")
+	ratio, ok := tm.ColumnWidthRatio("column-2")
+	assert.True(t, ok)
+	assert.Equal(t, 0.7, ratio)
+	// columns of older API responses have no ratio
+	_, ok = tm.ColumnWidthRatio("column-3")
+	assert.False(t, ok)
+}
+
+func TestBlockColors(t *testing.T) {
+	response := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": [
+			{"object": "block", "id": "callout-1", "type": "callout", "callout": {"rich_text": [], "color": "red_background"}},
+			{"object": "block", "id": "quote-1", "type": "quote", "quote": {"rich_text": [], "color": "default"}},
+			{"object": "block", "id": "paragraph-1", "type": "paragraph", "paragraph": {"rich_text": [], "color": "blue"}}]}`
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	config := Config{Markdown: Markdown{blockColors: newBlockColors()}}
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: wrapTransport(response, config)}))
+
+	_, err := retrieveBlockChildren(client, "page-1", 1)
+	assert.NoError(t, err)
+
+	tm := newToMarkdown(nil, config.Markdown, "post")
+	color, ok := tm.BlockColor("callout-1")
+	assert.True(t, ok)
+	assert.Equal(t, "red_background", color)
+	color, _ = tm.BlockColor("quote-1")
+	assert.Equal(t, "default", color)
+	// only callouts and quotes are recorded
+	_, ok = tm.BlockColor("paragraph-1")
+	assert.False(t, ok)
+}
+
+func TestQueryDatabasePages(t *testing.T) {
+	var query notion.DatabaseQuery
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{
+		Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			assert.Equal(t, "/v1/databases/db-1/query", req.URL.Path)
+			assert.NoError(t, json.NewDecoder(req.Body).Decode(&query))
+			body := `{"object": "list", "has_more": true, "next_cursor": "page-3", "results": [
+				{"object": "page", "id": "page-1", "parent": {"type": "database_id", "database_id": "db-1"},
+					"properties": {"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "Go Proverbs"}}]}}},
+				{"object": "page", "id": "page-2", "parent": {"type": "database_id", "database_id": "db-1"},
+					"properties": {"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "Draft ideas"}}]}}}]}`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+		}),
+	}))
+
+	pages, err := queryDatabasePages(client, "db-1", 2, Markdown{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, query.PageSize)
+	assert.Equal(t, []tomarkdown.DatabasePage{{ID: "page-1", Title: "Go Proverbs"}, {ID: "page-2", Title: "Draft ideas"}}, pages)
+}
+
+func TestPageEditors(t *testing.T) {
+	var userLookups int
+	response := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body := `{"object": "list", "has_more": false, "results": [
+			{"object": "page", "id": "page-1", "parent": {"type": "workspace"}, "properties": {},
+				"created_by": {"object": "user", "id": "user-1"}, "last_edited_by": {"object": "user", "id": "user-2"}}]}`
+		if strings.HasPrefix(req.URL.Path, "/v1/users/") {
+			userLookups++
+			names := map[string]string{"user-1": "Ada Lovelace", "user-2": "Charles Babbage"}
+			id := strings.TrimPrefix(req.URL.Path, "/v1/users/")
+			body = `{"object": "user", "id": "` + id + `", "type": "person", "name": "` + names[id] + `"}`
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+	})
+	markdown := Markdown{CreatedByField: "author", LastEditedByField: "editor"}
+	markdown.editors = newPageEditors(markdown)
+	config := Config{Notion: Notion{DatabaseID: "db-1"}, Markdown: markdown}
+	client := notion.NewClient("secret", notion.WithHTTPClient(&http.Client{Transport: wrapTransport(response, config)}))
+
+	q, err := queryDatabase(client, config.Notion)
+	assert.NoError(t, err)
+	tm := newToMarkdown(client, config.Markdown, "post")
+	tm.WithFrontMatter(q.Results[0])
+	assert.Equal(t, "Ada Lovelace", tm.FrontMatter["author"])
+	assert.Equal(t, "Charles Babbage", tm.FrontMatter["editor"])
+
+	// users are looked up once
+	tm = newToMarkdown(client, config.Markdown, "post")
+	tm.WithFrontMatter(q.Results[0])
+	assert.Equal(t, 2, userLookups)
+	assert.Nil(t, newPageEditors(Markdown{}))
+}
+
+func TestMissingSecret(t *testing.T) {
+	t.Setenv("NOTION_SECRET", "")
+	dir := t.TempDir()
+	config := Config{Markdown: Markdown{PostSavePath: filepath.Join(dir, "posts")}}
+
+	err := Run(config, nil, nil, false)
+	assert.Equal(t, errMissingSecret, err)
+	assert.Contains(t, err.Error(), ".env")
+	assert.NoDirExists(t, config.PostSavePath)
+
+	assert.Equal(t, errMissingSecret, PreviewPage(config, "page-1", io.Discard))
+	assert.Equal(t, errMissingSecret, DumpPage(config, "page-1", io.Discard))
+}
+
+// mockNotionAPI serves pages and blocks from memory and records page updates.
+// Methods it doesn't implement panic through the nil embedded interface.
+type mockNotionAPI struct {
+	NotionAPI
+	pages    []notion.Page
+	children map[string][]notion.Block
+	updates  map[string]notion.UpdatePageParams
+	err      error
+}
+
+func (m *mockNotionAPI) QueryDatabase(_ context.Context, _ string, _ *notion.DatabaseQuery) (notion.DatabaseQueryResponse, error) {
+	return notion.DatabaseQueryResponse{Results: m.pages}, m.err
+}
+
+// FindBlockChildrenByID returns one block per call, so every level is paged
+func (m *mockNotionAPI) FindBlockChildrenByID(_ context.Context, blockID string, query *notion.PaginationQuery) (notion.BlockChildrenResponse, error) {
+	if m.err != nil {
+		return notion.BlockChildrenResponse{}, m.err
+	}
+	var res notion.BlockChildrenResponse
+	children := m.children[blockID]
+	i := 0
+	if query.StartCursor != "" {
+		i, _ = strconv.Atoi(query.StartCursor)
+	}
+	if i < len(children) {
+		res.Results = children[i : i+1]
+	}
+	if i+1 < len(children) {
+		next := strconv.Itoa(i + 1)
+		res.HasMore, res.NextCursor = true, &next
+	}
+	return res, nil
+}
+
+func (m *mockNotionAPI) UpdatePage(_ context.Context, pageID string, params notion.UpdatePageParams) (notion.Page, error) {
+	if m.updates == nil {
+		m.updates = make(map[string]notion.UpdatePageParams)
+	}
+	m.updates[pageID] = params
+	return notion.Page{ID: pageID}, m.err
+}
+
+func TestQueryDatabaseMock(t *testing.T) {
+	client := &mockNotionAPI{pages: []notion.Page{testPage("page-1", "First Post")}}
+	res, err := queryDatabase(client, Notion{DatabaseID: "db-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, client.pages, res.Results)
+
+	client.err = errors.New("unauthorized")
+	_, err = queryDatabase(client, Notion{DatabaseID: "db-1"})
+	assert.Equal(t, client.err, err)
+}
+
+func TestQueryBlockChildrenMock(t *testing.T) {
+	toggle := notion.Block{
+		ID: "toggle-1", Type: notion.BlockTypeToggle, HasChildren: true,
+		Toggle: &notion.RichTextBlock{},
+	}
+	client := &mockNotionAPI{children: map[string][]notion.Block{
+		"page-1":   {testParagraph("First"), toggle, testParagraph("Last")},
+		"toggle-1": {testParagraph("Inside"), testParagraph("Also inside")},
+	}}
+
+	blocks, err := queryBlockChildren(client, "page-1", DefaultMaxBlockDepth)
+	assert.NoError(t, err)
+	if assert.Len(t, blocks, 3) {
+		assert.Equal(t, "Last", blocks[2].Paragraph.Text[0].Text.Content)
+		assert.Len(t, blocks[1].Toggle.Children, 2)
+	}
+
+	client.err = errors.New("not found")
+	_, err = queryBlockChildren(client, "page-1", DefaultMaxBlockDepth)
+	assert.Equal(t, client.err, err)
+}
+
+func TestChangeStatusMock(t *testing.T) {
+	page := testPage("page-1", "First Post")
+	page.Properties.(notion.DatabasePageProperties)["Status"] = notion.DatabasePageProperty{
+		Type: notion.DBPropTypeSelect, Select: &notion.SelectOptions{Name: "Ready"},
+	}
+	config := Notion{FilterProp: "Status", PublishedValue: "Published"}
+	logger := newRunLogger(io.Discard, false, LogFormatText)
+
+	client := &mockNotionAPI{}
+	assert.True(t, changeStatus(client, page, config, logger))
+	props := *client.updates["page-1"].DatabasePageProperties
+	assert.Equal(t, "Published", props["Status"].Select.Name)
+
+	client = &mockNotionAPI{err: errors.New("conflict")}
+	assert.False(t, changeStatus(client, page, config, logger))
+}
@@ -0,0 +1,143 @@
+package generator
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding a single token that refills every
+// interval. It is shared by all workers so the combined request rate never
+// exceeds the configured limit.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter returns a limiter allowing rps requests per second, or nil
+// (no limit) when rps is not positive.
+func newRateLimiter(rps float64) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / rps)}
+}
+
+// Wait blocks until the caller is allowed to send the next request
+func (l *rateLimiter) Wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// rateLimitedTransport paces every request, including retries, through a limiter
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.limiter.Wait()
+	return t.base.RoundTrip(req)
+}
+
+// workerLimiter bounds the number of pages processed at the same time. A fixed
+// limiter always allows max workers. An adaptive one starts with a single
+// worker, adds one after as many successful requests in a row as workers are
+// allowed, and halves the workers whenever Notion answers 429 Too Many Requests.
+type workerLimiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	adaptive  bool
+	limit     int
+	max       int
+	active    int
+	successes int
+}
+
+// newWorkerLimiter returns a limiter for up to max workers, starting with one
+// worker when adaptive is set.
+func newWorkerLimiter(max int, adaptive bool) *workerLimiter {
+	if max < 1 {
+		max = 1
+	}
+	w := &workerLimiter{adaptive: adaptive, limit: max, max: max}
+	if adaptive {
+		w.limit = 1
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// acquire blocks until another worker is allowed
+func (w *workerLimiter) acquire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.active >= w.limit {
+		w.cond.Wait()
+	}
+	w.active++
+}
+
+func (w *workerLimiter) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.active--
+	w.cond.Broadcast()
+}
+
+// observe adapts the limit to the status code of a Notion API response
+func (w *workerLimiter) observe(statusCode int) {
+	if !w.adaptive {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		w.successes = 0
+		if w.limit > 1 {
+			w.limit /= 2
+		}
+	case statusCode < 300:
+		w.successes++
+		if w.successes >= w.limit && w.limit < w.max {
+			w.successes = 0
+			w.limit++
+			w.cond.Broadcast()
+		}
+	}
+}
+
+// current returns the number of workers allowed right now
+func (w *workerLimiter) current() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.limit
+}
+
+// throttleTransport reports the status of every response, including retried
+// ones, to an adaptive workerLimiter
+type throttleTransport struct {
+	base    http.RoundTripper
+	workers *workerLimiter
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		t.workers.observe(resp.StatusCode)
+	}
+	return resp, err
+}
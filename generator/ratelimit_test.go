@@ -0,0 +1,115 @@
+package generator
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	var mu sync.Mutex
+	var sent []time.Time
+	transport := &rateLimitedTransport{
+		base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			sent = append(sent, time.Now())
+			mu.Unlock()
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}),
+		limiter: newRateLimiter(20),
+	}
+
+	// several workers share the same transport, like the parallel path in Run
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "https://api.notion.com/v1/blocks", nil)
+			_, err := transport.RoundTrip(req)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(sent, func(i, j int) bool { return sent[i].Before(sent[j]) })
+	for i := 1; i < len(sent); i++ {
+		// allow a little scheduling jitter below the 50ms interval
+		assert.GreaterOrEqual(t, int64(sent[i].Sub(sent[i-1])), int64(45*time.Millisecond))
+	}
+}
+
+func TestNewRateLimiterDisabled(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0))
+	start := time.Now()
+	newRateLimiter(0).Wait()
+	assert.Less(t, int64(time.Since(start)), int64(time.Millisecond))
+}
+
+func TestWorkerLimiterBacksOff(t *testing.T) {
+	workers := newWorkerLimiter(8, true)
+	assert.Equal(t, 1, workers.current())
+
+	// the fake API throttles more than 3 concurrent requests
+	var mu sync.Mutex
+	inFlight, maxInFlight, throttled := 0, 0, 0
+	transport := &throttleTransport{
+		base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			status := http.StatusOK
+			if inFlight > 3 {
+				status = http.StatusTooManyRequests
+				throttled++
+			}
+			mu.Unlock()
+			time.Sleep(2 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return &http.Response{StatusCode: status, Body: http.NoBody}, nil
+		}),
+		workers: workers,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		workers.acquire()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer workers.release()
+			req, _ := http.NewRequest(http.MethodGet, "https://api.notion.com/v1/blocks", nil)
+			_, err := transport.RoundTrip(req)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	// the workers ramped up into the throttling and were cut back each time
+	assert.Greater(t, throttled, 0)
+	assert.Less(t, maxInFlight, 8)
+	assert.LessOrEqual(t, workers.current(), 4)
+}
+
+func TestWorkerLimiterFixed(t *testing.T) {
+	workers := newWorkerLimiter(4, false)
+	assert.Equal(t, 4, workers.current())
+	workers.observe(http.StatusOK)
+	workers.observe(http.StatusTooManyRequests)
+	assert.Equal(t, 4, workers.current())
+	assert.Equal(t, 1, newWorkerLimiter(0, false).current())
+}
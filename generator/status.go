@@ -0,0 +1,276 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bonaysoft/notion-md-gen/pkg/tomarkdown"
+)
+
+// statusTransport translates Notion status properties, which go-notion
+// doesn't know, to and from the select properties it does: the option of a
+// status property in a page response is copied to its "select" field, and
+// select filters and updates of status properties are sent as "status". The
+// status properties of a database are read from its schema the first time it
+// is queried with a select filter, those of a page from the responses it came
+// in. It is safe for concurrent use.
+type statusTransport struct {
+	base http.RoundTripper
+
+	mu sync.Mutex
+	// status property names by database or page ID
+	props map[string]map[string]bool
+}
+
+func newStatusTransport(base http.RoundTripper) *statusTransport {
+	return &statusTransport{base: base, props: make(map[string]map[string]bool)}
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	kind, id := notionEndpoint(req)
+	if kind == "" {
+		return t.base.RoundTrip(req)
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if req, err = t.translateRequest(req, kind, id); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = t.translateResponse(body, kind)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
+}
+
+// notionEndpoint returns "query" and the database ID for database queries,
+// "page" and the page ID for page requests, and an empty kind otherwise.
+func notionEndpoint(req *http.Request) (kind, id string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	n := len(parts)
+	switch {
+	case req.Method == http.MethodPost && n >= 3 && parts[n-3] == "databases" && parts[n-1] == "query":
+		return "query", parts[n-2]
+	case n >= 2 && parts[n-2] == "pages":
+		return "page", parts[n-1]
+	}
+	return "", ""
+}
+
+// translateRequest sends the select filters and updates of status properties
+// in the body of req as status ones.
+func (t *statusTransport) translateRequest(req *http.Request, kind, id string) (*http.Request, error) {
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var changed bool
+	var payload map[string]interface{}
+	if decodeJSON(body, &payload) == nil {
+		switch kind {
+		case "query":
+			if filter, ok := payload["filter"]; ok && hasSelectFilter(filter) {
+				changed = renameStatusFilters(filter, t.databaseStatus(req, id))
+			}
+		case "page":
+			if props, ok := payload["properties"].(map[string]interface{}); ok {
+				changed = renameStatus(props, t.status(id))
+			}
+		}
+	}
+	if changed {
+		if translated, err := json.Marshal(payload); err == nil {
+			body = translated
+		}
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return req, nil
+}
+
+// translateResponse copies the options of the status properties in the pages
+// of a query or page response to their select field, and records the names
+// of these properties by page ID.
+func (t *statusTransport) translateResponse(body []byte, kind string) []byte {
+	var payload map[string]interface{}
+	if decodeJSON(body, &payload) != nil {
+		return body
+	}
+	pages := []interface{}{payload}
+	if kind == "query" {
+		pages, _ = payload["results"].([]interface{})
+	}
+
+	var changed bool
+	for _, page := range pages {
+		page, _ := page.(map[string]interface{})
+		props, _ := page["properties"].(map[string]interface{})
+		id, _ := page["id"].(string)
+		status := make(map[string]bool)
+		for name, prop := range props {
+			prop, _ := prop.(map[string]interface{})
+			if prop["type"] != string(tomarkdown.DBPropTypeStatus) {
+				continue
+			}
+			status[name] = true
+			if option, ok := prop["status"]; ok {
+				prop["select"] = option
+				changed = true
+			}
+		}
+		if id != "" && len(status) > 0 {
+			t.mu.Lock()
+			t.props[id] = status
+			t.mu.Unlock()
+		}
+	}
+	if !changed {
+		return body
+	}
+	translated, err := json.Marshal(payload)
+	if err != nil {
+		return body
+	}
+	return translated
+}
+
+// status returns the recorded status property names of a page or database
+func (t *statusTransport) status(id string) map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.props[id]
+}
+
+// databaseStatus returns the status property names of the database queried by
+// req, fetching its schema the first time. A failed fetch is not recorded, the
+// query then goes out unchanged.
+func (t *statusTransport) databaseStatus(req *http.Request, databaseID string) map[string]bool {
+	t.mu.Lock()
+	status, ok := t.props[databaseID]
+	t.mu.Unlock()
+	if ok {
+		return status
+	}
+
+	schemaReq := req.Clone(req.Context())
+	schemaReq.Method = http.MethodGet
+	schemaReq.URL.Path = strings.TrimSuffix(req.URL.Path, "/query")
+	schemaReq.Body = http.NoBody
+	schemaReq.GetBody = nil
+	schemaReq.ContentLength = 0
+	schemaReq.Header.Del("Content-Type")
+	resp, err := t.base.RoundTrip(schemaReq)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Type string `json:"type"`
+		} `json:"properties"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&schema); err != nil {
+		return nil
+	}
+	status = make(map[string]bool)
+	for name, prop := range schema.Properties {
+		if prop.Type == string(tomarkdown.DBPropTypeStatus) {
+			status[name] = true
+		}
+	}
+	t.mu.Lock()
+	t.props[databaseID] = status
+	t.mu.Unlock()
+	return status
+}
+
+// decodeJSON decodes data into v keeping numbers as they are written
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// hasSelectFilter reports whether a query filter has a select condition
+func hasSelectFilter(filter interface{}) bool {
+	switch filter := filter.(type) {
+	case map[string]interface{}:
+		if _, ok := filter["select"]; ok {
+			return true
+		}
+		return hasSelectFilter(filter["or"]) || hasSelectFilter(filter["and"])
+	case []interface{}:
+		for _, f := range filter {
+			if hasSelectFilter(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renameStatusFilters turns the select conditions on status properties of a
+// query filter into status conditions. It reports whether any changed.
+func renameStatusFilters(filter interface{}, status map[string]bool) bool {
+	var changed bool
+	switch filter := filter.(type) {
+	case map[string]interface{}:
+		name, _ := filter["property"].(string)
+		if condition, ok := filter["select"]; ok && status[name] {
+			filter["status"] = condition
+			delete(filter, "select")
+			changed = true
+		}
+		for _, group := range []string{"or", "and"} {
+			if renameStatusFilters(filter[group], status) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, f := range filter {
+			if renameStatusFilters(f, status) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// renameStatus sends the select values of status properties in a page update
+// as status values. It reports whether any changed.
+func renameStatus(props map[string]interface{}, status map[string]bool) bool {
+	var changed bool
+	for name, prop := range props {
+		prop, _ := prop.(map[string]interface{})
+		if option, ok := prop["select"]; ok && status[name] {
+			prop["status"] = option
+			delete(prop, "select")
+			changed = true
+		}
+	}
+	return changed
+}
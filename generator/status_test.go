@@ -0,0 +1,88 @@
+package generator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// statusDatabase serves a database whose Status property is of the given
+// type, recording the query filter and the page updates it receives.
+type statusDatabase struct {
+	propType string
+	filter   map[string]interface{}
+	updates  []map[string]interface{}
+}
+
+func (db *statusDatabase) RoundTrip(req *http.Request) (*http.Response, error) {
+	value := `"` + db.propType + `": {"id": "s1", "name": "Ready", "color": "blue"}`
+	body := `{"object": "list", "has_more": false, "results": []}`
+	switch {
+	case req.Method == http.MethodGet && req.URL.Path == "/v1/databases/db-1":
+		body = `{"object": "database", "id": "db-1", "properties": {
+			"Name": {"id": "title", "type": "title", "title": {}},
+			"Status": {"id": "status", "type": "` + db.propType + `", "` + db.propType + `": {}}}}`
+	case strings.HasSuffix(req.URL.Path, "/query"):
+		var query struct {
+			Filter map[string]interface{} `json:"filter"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			return nil, err
+		}
+		db.filter = query.Filter
+		body = `{"object": "list", "has_more": false, "results": [{"object": "page", "id": "page-1",
+			"parent": {"type": "database_id", "database_id": "db-1"},
+			"properties": {
+				"Name": {"id": "title", "type": "title", "title": [{"type": "text", "text": {"content": "First Post"}}]},
+				"Status": {"id": "status", "type": "` + db.propType + `", ` + value + `}}}]}`
+	case req.Method == http.MethodPatch:
+		var update map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+			return nil, err
+		}
+		db.updates = append(db.updates, update)
+		body = `{"object": "page", "id": "page-1", "parent": {"type": "database_id", "database_id": "db-1"}, "properties": {}}`
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func TestStatusProperty(t *testing.T) {
+	for _, propType := range []string{"status", "select"} {
+		t.Run(propType, func(t *testing.T) {
+			t.Setenv("NOTION_SECRET", "secret")
+			dir := t.TempDir()
+			db := &statusDatabase{propType: propType}
+			config := Config{
+				Notion: Notion{DatabaseID: "db-1", FilterProp: "Status", FilterValue: []string{"Ready"}, PublishedValue: "Published"},
+				Markdown: Markdown{
+					PostSavePath:  filepath.Join(dir, "posts"),
+					ImageSavePath: filepath.Join(dir, "images"),
+				},
+				transport: db,
+			}
+			assert.NoError(t, Run(config, nil, nil, false))
+
+			// the publish filter uses the type of the property
+			filter := map[string]interface{}{
+				"property": "Status",
+				propType:   map[string]interface{}{"equals": "Ready"},
+			}
+			assert.Equal(t, map[string]interface{}{"or": []interface{}{filter}}, db.filter)
+
+			content, err := os.ReadFile(filepath.Join(dir, "posts", "first-post.md"))
+			assert.NoError(t, err)
+			assert.Contains(t, string(content), "status: Ready\n")
+
+			if assert.Len(t, db.updates, 1) {
+				published := map[string]interface{}{propType: map[string]interface{}{"name": "Published"}}
+				assert.Equal(t, map[string]interface{}{"Status": published}, db.updates[0]["properties"])
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package generator
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// cyrillicLatin romanizes the Cyrillic letters of Russian, Ukrainian and
+// Belarusian, following the common transliteration used in URLs.
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u",
+}
+
+// transliterate turns a title into ASCII for file names: Cyrillic letters are
+// romanized, accents removed and symbols such as emoji dropped. Letters of
+// other scripts, e.g. CJK, are kept as they are.
+func transliterate(title string) string {
+	var b strings.Builder
+	for _, r := range norm.NFC.String(title) {
+		lower := unicode.ToLower(r)
+		if latin, ok := cyrillicLatin[lower]; ok {
+			if lower != r && latin != "" {
+				latin = strings.ToUpper(latin[:1]) + latin[1:]
+			}
+			b.WriteString(latin)
+			continue
+		}
+		if r >= unicode.MaxASCII && !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) {
+			// emoji and other symbols
+			continue
+		}
+		// drop the accents NFD splits off, keep the letters of other scripts whole
+		if decomposed := norm.NFD.String(string(r)); decomposed[0] < unicode.MaxASCII {
+			for _, c := range decomposed {
+				if !unicode.Is(unicode.Mn, c) {
+					b.WriteRune(c)
+				}
+			}
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
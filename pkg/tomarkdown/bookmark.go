@@ -0,0 +1,117 @@
+package tomarkdown
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/otiai10/opengraph"
+)
+
+// BookmarkInfo is the opengraph metadata shown for a bookmark.
+type BookmarkInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// BookmarkCache keeps the metadata of fetched bookmarks by URL so a link
+// shared by several pages is only looked up once. It is safe for concurrent use.
+type BookmarkCache struct {
+	mu      sync.Mutex
+	entries map[string]BookmarkInfo
+}
+
+// NewBookmarkCache returns an empty in-memory cache.
+func NewBookmarkCache() *BookmarkCache {
+	return &BookmarkCache{entries: make(map[string]BookmarkInfo)}
+}
+
+// LoadBookmarkCache reads a cache written by Save. A missing file or an
+// empty path gives an empty cache.
+func LoadBookmarkCache(path string) (*BookmarkCache, error) {
+	cache := NewBookmarkCache()
+	if path == "" {
+		return cache, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &cache.entries); err != nil {
+		return nil, err
+	}
+	if cache.entries == nil {
+		cache.entries = make(map[string]BookmarkInfo)
+	}
+	return cache, nil
+}
+
+// Save writes the cache to path as JSON; an empty path is a no-op.
+func (c *BookmarkCache) Save(path string) error {
+	if path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	content, err := json.MarshalIndent(c.entries, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func (c *BookmarkCache) get(url string) (BookmarkInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info, ok := c.entries[url]
+	return info, ok
+}
+
+func (c *BookmarkCache) set(url string, info BookmarkInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[url] = info
+}
+
+// fetchBookmark looks up the opengraph metadata of url, using tm.BookmarkCache
+// when set. Failed lookups are not cached.
+func (tm *ToMarkdown) fetchBookmark(url string) (BookmarkInfo, error) {
+	if tm.BookmarkCache != nil {
+		if info, ok := tm.BookmarkCache.get(url); ok {
+			return info, nil
+		}
+	}
+
+	client := tm.BookmarkClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	og, err := opengraph.Fetch(url, client)
+	if err != nil {
+		return BookmarkInfo{}, err
+	}
+	og.ToAbsURL()
+	info := BookmarkInfo{Title: og.Title, Description: og.Description}
+	for _, img := range og.Image {
+		if img != nil && img.URL != "" {
+			info.Image = img.URL
+			break
+		}
+	}
+
+	if tm.BookmarkCache != nil {
+		tm.BookmarkCache.set(url, info)
+	}
+	return info, nil
+}
@@ -0,0 +1,57 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// calloutEmoji returns the emoji icon of a callout, or an empty string for
+// callouts without an icon or with an image icon.
+func calloutEmoji(callout *notion.Callout) string {
+	if callout.Icon == nil || callout.Icon.Emoji == nil {
+		return ""
+	}
+	return *callout.Icon.Emoji
+}
+
+// plainCallout renders a callout as a blockquote starting with its emoji, the
+// plain Markdown fallback used without extended syntax.
+func (tm *ToMarkdown) plainCallout(callout *notion.Callout, depth int) string {
+	text := tm.convertRichText(callout.Text)
+	if emoji := calloutEmoji(callout); emoji != "" {
+		text = emoji + " " + text
+	}
+	return tm.prefixQuote(text, depth)
+}
+
+// childrenBody renders the children of a block at depth for the Body of its
+// template. Callout shortcodes write them at depth 0, the shortcode already
+// sets them apart, toggles at their own depth inside <details>.
+func (tm *ToMarkdown) childrenBody(block MdBlock, depth int) (string, error) {
+	parent := tm.ContentBuffer
+	tm.ContentBuffer = new(bytes.Buffer)
+	err := tm.GenContentBlocks(getChildrenBlocks(block), depth)
+	body := strings.Trim(tm.ContentBuffer.String(), "\n")
+	tm.ContentBuffer = parent
+	return body, err
+}
+
+// colorClass returns the CSS class for the Notion color of a callout or quote:
+// the block type and the color, e.g. callout-red for red_background and
+// callout-red-text for the red text color. Blocks in the default color have
+// no class.
+func (tm *ToMarkdown) colorClass(block MdBlock) string {
+	if tm.BlockColor == nil {
+		return ""
+	}
+	color, ok := tm.BlockColor(block.ID)
+	if !ok || color == "" || color == "default" {
+		return ""
+	}
+	if strings.HasSuffix(color, "_background") {
+		return string(block.Type) + "-" + strings.TrimSuffix(color, "_background")
+	}
+	return string(block.Type) + "-" + color + "-text"
+}
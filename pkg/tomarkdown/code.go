@@ -0,0 +1,43 @@
+package tomarkdown
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// Supported values for ToMarkdown.CodeCaption.
+const (
+	// CodeCaptionLine writes the caption as an emphasized line above the code block
+	CodeCaptionLine = "line"
+	// CodeCaptionTitle adds the caption as {title="..."} attribute to the fence, as used by Hugo
+	CodeCaptionTitle = "title"
+)
+
+// codeInfo returns the info string of a code fence: the language, followed
+// by the title attribute in CodeCaptionTitle mode.
+func (tm *ToMarkdown) codeInfo(code *notion.Code) string {
+	var info string
+	if code.Language != nil {
+		info = *code.Language
+	}
+	caption := strings.TrimSpace(ConvertRichText(code.Caption))
+	if tm.CodeCaption != CodeCaptionTitle || caption == "" {
+		return info
+	}
+	return strings.TrimSpace(info + " {title=" + strconv.Quote(caption) + "}")
+}
+
+// codeCaptionLine returns the caption line written above a code block in
+// CodeCaptionLine mode, or an empty string.
+func (tm *ToMarkdown) codeCaptionLine(code *notion.Code) string {
+	if tm.CodeCaption != CodeCaptionLine {
+		return ""
+	}
+	caption := strings.TrimSpace(tm.convertRichText(code.Caption))
+	if caption == "" {
+		return ""
+	}
+	return "*" + caption + "*"
+}
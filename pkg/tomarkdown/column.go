@@ -0,0 +1,52 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// genColumnChildren renders the columns of a column list, or the content of a
+// column, and closes the <div> opened by their template. Column content is
+// written at depth 0, it is Markdown inside an HTML block.
+func (tm *ToMarkdown) genColumnChildren(block MdBlock) error {
+	if block.Type == notion.BlockTypeColumnList {
+		if err := tm.GenContentBlocks(getChildrenBlocks(block), block.Depth+1); err != nil {
+			return err
+		}
+		tm.ContentBuffer.WriteString("</div>\n\n")
+		return nil
+	}
+
+	parent := tm.ContentBuffer
+	tm.ContentBuffer = new(bytes.Buffer)
+	err := tm.GenContentBlocks(getChildrenBlocks(block), 0)
+	content := strings.Trim(tm.ContentBuffer.String(), "\n")
+	tm.ContentBuffer = parent
+	if err != nil {
+		return err
+	}
+
+	// the blank lines let Markdown renderers parse the content of the <div>
+	if content != "" {
+		parent.WriteString("\n" + content + "\n")
+	}
+	parent.WriteString("\n</div>\n")
+	return nil
+}
+
+// columnFlex returns the CSS flex-grow of a column: its width ratio from
+// ColumnWidthRatio, or 1 to share the row equally when it has none.
+func (tm *ToMarkdown) columnFlex(columnID string) string {
+	if tm.ColumnWidthRatio == nil {
+		return "1"
+	}
+	ratio, ok := tm.ColumnWidthRatio(columnID)
+	if !ok || ratio <= 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(math.Round(ratio*10000)/10000, 'f', -1, 64)
+}
@@ -0,0 +1,49 @@
+package tomarkdown
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DatabasePage is a page of a database listed under a child database block
+type DatabasePage struct {
+	ID    string
+	Title string
+}
+
+// resolveDatabasePages queries the first LinkedDatabaseRows pages of a child
+// database, which are listed below its title.
+func (tm *ToMarkdown) resolveDatabasePages(block *MdBlock) error {
+	if tm.LinkedDatabaseRows <= 0 || tm.QueryDatabasePages == nil {
+		return nil
+	}
+	pages, err := tm.QueryDatabasePages(block.ID, tm.LinkedDatabaseRows)
+	if err != nil {
+		return fmt.Errorf("querying database %s: %w", block.ID, err)
+	}
+	if len(pages) > tm.LinkedDatabaseRows {
+		pages = pages[:tm.LinkedDatabaseRows]
+	}
+	block.DatabasePages = pages
+	return nil
+}
+
+// databasePage renders a page of a child database as a link to its generated
+// file, or as its bare title when it is not part of the export.
+func (tm *ToMarkdown) databasePage(page DatabasePage) string {
+	title := strings.TrimSpace(page.Title)
+	if title == "" {
+		title = "Untitled"
+	}
+	link := tm.pageLink(page.ID)
+	if link != "" && tm.wikilinks() {
+		return wikilink(title, link)
+	}
+	if tm.EscapeMarkdown {
+		title = markdownEscaper.Replace(title)
+	}
+	if link != "" {
+		return tm.formatLink(title, link)
+	}
+	return title
+}
@@ -0,0 +1,22 @@
+package tomarkdown
+
+import (
+	"fmt"
+	"strings"
+)
+
+// equationTag numbers the next equation block with a \tag when
+// EquationNumbering is set, counting from 1 on every page.
+func (tm *ToMarkdown) equationTag() string {
+	if !tm.EquationNumbering {
+		return ""
+	}
+	tm.equations++
+	return fmt.Sprintf(` \tag{%d}`, tm.equations)
+}
+
+// inlineEquation writes an equation of rich text, e.g. in a paragraph or a
+// caption, as inline math between single dollar signs.
+func inlineEquation(expression string) string {
+	return "$" + strings.TrimSpace(expression) + "$"
+}
@@ -0,0 +1,98 @@
+package tomarkdown
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Front matter formats, see FrontMatterFormat
+const (
+	FrontMatterYAML = "yaml"
+	FrontMatterTOML = "toml"
+	FrontMatterJSON = "json"
+)
+
+// FrontMatterFormats are the formats supported by FrontMatterFormat.
+var FrontMatterFormats = []string{FrontMatterYAML, FrontMatterTOML, FrontMatterJSON}
+
+// FrontMatterCaption marks a YAML code block as the page's own front matter
+// when it is the first block of the page.
+const FrontMatterCaption = "FrontMatter"
+
+// extractFrontMatterBlock merges the YAML of a leading front matter code block
+// into tm.FrontMatter, overriding the values taken from the page properties,
+// and returns the blocks without it.
+func (tm *ToMarkdown) extractFrontMatterBlock(blocks []notion.Block) ([]notion.Block, error) {
+	if len(blocks) == 0 || !isFrontMatterBlock(blocks[0]) {
+		return blocks, nil
+	}
+	fields := make(map[string]interface{})
+	if err := yaml.Unmarshal([]byte(ConvertRichText(blocks[0].Code.Text)), &fields); err != nil {
+		return nil, fmt.Errorf("parsing front matter block: %w", err)
+	}
+	for key, value := range fields {
+		tm.FrontMatter[key] = value
+	}
+	return blocks[1:], nil
+}
+
+func isFrontMatterBlock(block notion.Block) bool {
+	code := block.Code
+	if block.Type != notion.BlockTypeCode || code == nil || code.Language == nil || *code.Language != "yaml" {
+		return false
+	}
+	return strings.EqualFold(strings.TrimSpace(ConvertRichText(code.Caption)), FrontMatterCaption)
+}
+
+// marshalFrontMatter encodes the front matter fields in format and returns
+// them with the fence line written before and after them. JSON front matter
+// is a bare object without fences.
+func marshalFrontMatter(format string, fields map[string]interface{}) (string, []byte, error) {
+	switch format {
+	case FrontMatterTOML:
+		tree, err := toml.TreeFromMap(fields)
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := tree.Marshal()
+		return "+++\n", data, err
+	case FrontMatterJSON:
+		data, err := json.MarshalIndent(fields, "", "  ")
+		return "", append(data, '\n'), err
+	default:
+		data, err := yaml.Marshal(fields)
+		return "---\n", data, err
+	}
+}
+
+// fieldMapping is FrontMatterMapping with lowercased property names
+type fieldMapping map[string]string
+
+func (tm *ToMarkdown) frontMatterMapping() fieldMapping {
+	mapping := make(fieldMapping, len(tm.FrontMatterMapping))
+	for property, key := range tm.FrontMatterMapping {
+		mapping[strings.ToLower(property)] = key
+	}
+	return mapping
+}
+
+// key returns the front matter key a property is renamed to
+func (m fieldMapping) key(property string) (string, bool) {
+	key, ok := m[strings.ToLower(property)]
+	return key, ok
+}
+
+// isTarget reports whether a property is renamed to the front matter key name
+func (m fieldMapping) isTarget(name string) bool {
+	for _, key := range m {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package tomarkdown
+
+import (
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// Heading is a heading rendered by GenContentBlocks, e.g. for a table of contents.
+type Heading struct {
+	// Text is the plain text of the heading
+	Text string
+	// Level is 1 to 3, like the Notion heading types
+	Level int
+	// Slug is the anchor of the heading, as generated by the slugify template function
+	Slug string
+}
+
+// Headings returns the headings of the last rendered page in document order.
+func (tm *ToMarkdown) Headings() []Heading {
+	return tm.headings
+}
+
+// collectHeading remembers a heading block for Headings
+func (tm *ToMarkdown) collectHeading(block notion.Block) {
+	var level int
+	var text []notion.RichText
+	switch block.Type {
+	case notion.BlockTypeHeading1:
+		level, text = 1, block.Heading1.Text
+	case notion.BlockTypeHeading2:
+		level, text = 2, block.Heading2.Text
+	case notion.BlockTypeHeading3:
+		level, text = 3, block.Heading3.Text
+	default:
+		return
+	}
+	heading := strings.TrimSpace(plainText(text))
+	tm.headings = append(tm.headings, Heading{Text: heading, Level: level, Slug: slugify(heading)})
+}
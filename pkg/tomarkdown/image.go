@@ -0,0 +1,321 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// DefaultImageQuality is used when ImageQuality is not set
+const DefaultImageQuality = 80
+
+// ImageEncoder writes img to w in its format, at the given quality (1-100).
+// Lossless formats may ignore the quality.
+type ImageEncoder func(w io.Writer, img image.Image, quality int) error
+
+var imageEncoders = map[string]ImageEncoder{
+	"jpeg": func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	},
+	"png": func(w io.Writer, img image.Image, _ int) error {
+		return png.Encode(w, img)
+	},
+	"webp": encodeWebP,
+}
+
+// ImageIndex remembers the public path of every saved image by the hash of
+// its downloaded content, so the same image is written only once. It is safe
+// for concurrent use.
+type ImageIndex struct {
+	mu     sync.Mutex
+	images map[string]*indexedImage
+}
+
+// indexedImage is an image saved, or being saved, under path
+type indexedImage struct {
+	done chan struct{}
+	path string
+	err  error
+}
+
+// NewImageIndex returns an empty ImageIndex.
+func NewImageIndex() *ImageIndex {
+	return &ImageIndex{images: make(map[string]*indexedImage)}
+}
+
+// save returns the public path of the image with the content hash, calling
+// write to save it the first time. Callers with the same content wait for the
+// write in progress; a failed write is tried again by the next caller.
+func (idx *ImageIndex) save(hash string, write func() (string, error)) (string, error) {
+	idx.mu.Lock()
+	if image, ok := idx.images[hash]; ok {
+		idx.mu.Unlock()
+		<-image.done
+		if image.err != nil {
+			return idx.save(hash, write)
+		}
+		return image.path, nil
+	}
+	image := &indexedImage{done: make(chan struct{})}
+	idx.images[hash] = image
+	idx.mu.Unlock()
+
+	image.path, image.err = write()
+	if image.err != nil {
+		idx.mu.Lock()
+		delete(idx.images, hash)
+		idx.mu.Unlock()
+	}
+	close(image.done)
+	return image.path, image.err
+}
+
+// ImageStore keeps the downloaded images. Put saves the content of r under
+// name and returns the URL the Markdown links the image with, e.g. the URL of
+// an object uploaded to a CDN bucket.
+type ImageStore interface {
+	Put(name string, r io.Reader) (url string, err error)
+}
+
+// FileImageStore writes images into Dir and links them below VisitPath. It is
+// used when ToMarkdown.ImageStore is nil.
+type FileImageStore struct {
+	Dir       string
+	VisitPath string
+}
+
+// Put writes the image to a file name in Dir.
+func (s FileImageStore) Put(name string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return "", fmt.Errorf("%s: %s", s.Dir, err)
+	}
+	f, err := os.Create(filepath.Join(s.Dir, name))
+	if err != nil {
+		return "", fmt.Errorf("couldn't create image file: %s", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return "", fmt.Errorf("couldn't write image file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("couldn't write image file: %s", err)
+	}
+	return filepath.Join(s.VisitPath, name), nil
+}
+
+// DownloadLimiter bounds the number of images downloaded at the same time.
+// Converters sharing one limiter share its bound. A nil limiter is unbounded.
+type DownloadLimiter struct {
+	slots chan struct{}
+}
+
+// NewDownloadLimiter returns a limiter allowing n concurrent downloads, or nil
+// when n is not positive.
+func NewDownloadLimiter(n int) *DownloadLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &DownloadLimiter{slots: make(chan struct{}, n)}
+}
+
+func (l *DownloadLimiter) acquire() {
+	if l != nil {
+		l.slots <- struct{}{}
+	}
+}
+
+func (l *DownloadLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// inlineImage returns data as a data: URI if it is at most InlineImageMaxBytes
+// long. The media type is taken from the extension of path or the content.
+func (tm *ToMarkdown) inlineImage(data []byte, path string) (string, bool) {
+	if tm.InlineImageMaxBytes <= 0 || len(data) > tm.InlineImageMaxBytes {
+		return "", false
+	}
+	mediaType := mime.TypeByExtension(filepath.Ext(path))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data), true
+}
+
+// RegisterImageEncoder makes format available as an ImageConvert target, or
+// replaces the built-in jpeg, png or webp encoder, e.g. with one backed by
+// libwebp for lossy WebP images. It must be called before rendering.
+func RegisterImageEncoder(format string, encoder ImageEncoder) {
+	imageEncoders[strings.ToLower(format)] = encoder
+}
+
+// ImageFormats returns the formats ImageConvert accepts, in alphabetical order:
+// jpeg, png, webp and those added with RegisterImageEncoder.
+func ImageFormats() []string {
+	formats := make([]string, 0, len(imageEncoders))
+	for format := range imageEncoders {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// processImage downscales PNG and JPEG data wider than tm.MaxImageWidth and
+// transcodes it to tm.ImageConvert, rewriting the extension of both paths.
+// Other or undecodable content is returned untouched.
+func (tm *ToMarkdown) processImage(data []byte, localPath, visitPath string) ([]byte, string, string, error) {
+	contentType := http.DetectContentType(data)
+	if contentType != "image/png" && contentType != "image/jpeg" {
+		return data, localPath, visitPath, nil
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		// not decodable after all, keep the original bytes
+		return data, localPath, visitPath, nil
+	}
+
+	resized := false
+	if tm.MaxImageWidth > 0 && img.Bounds().Dx() > tm.MaxImageWidth {
+		img = resizeToWidth(img, tm.MaxImageWidth)
+		resized = true
+	}
+	if tm.ImageConvert != "" {
+		format = strings.ToLower(tm.ImageConvert)
+		localPath = replaceExt(localPath, format)
+		visitPath = replaceExt(visitPath, format)
+	} else if !resized {
+		return data, localPath, visitPath, nil
+	}
+
+	encoder, ok := imageEncoders[format]
+	if !ok {
+		return nil, "", "", fmt.Errorf("no encoder registered for image format %q", format)
+	}
+	quality := tm.ImageQuality
+	if quality <= 0 {
+		quality = DefaultImageQuality
+	}
+	var buf bytes.Buffer
+	if err := encoder(&buf, img, quality); err != nil {
+		return nil, "", "", fmt.Errorf("encoding image as %s: %w", format, err)
+	}
+	return buf.Bytes(), localPath, visitPath, nil
+}
+
+// resizeToWidth scales img down to width, keeping the aspect ratio. Every
+// destination pixel is the average of the source pixels it covers.
+func resizeToWidth(img image.Image, width int) image.Image {
+	src := img.Bounds()
+	height := src.Dy() * width / src.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := src.Min.Y + (y+1)*src.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := src.Min.X + (x+1)*src.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// replaceExt swaps the extension of path for "."+ext
+func replaceExt(path, ext string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "." + ext
+}
+
+// imageAlt returns the alt text of an image from its caption: the whole
+// caption, or the part before ImageTitleSeparator when that is set.
+func (tm *ToMarkdown) imageAlt(caption []notion.RichText) string {
+	if tm.ImageTitleSeparator == "" {
+		return tm.convertRichText(caption)
+	}
+	alt, _ := splitRichText(caption, tm.ImageTitleSeparator)
+	return strings.Join(strings.Fields(tm.convertRichText(alt)), " ")
+}
+
+// imageTitle returns the ` "title"` part of an image link, the caption after
+// ImageTitleSeparator, or an empty string when there is no title.
+func (tm *ToMarkdown) imageTitle(caption []notion.RichText) string {
+	if tm.ImageTitleSeparator == "" {
+		return ""
+	}
+	_, title := splitRichText(caption, tm.ImageTitleSeparator)
+	text := strings.Join(strings.Fields(plainText(title)), " ")
+	if text == "" {
+		return ""
+	}
+	return ` "` + strings.ReplaceAll(text, `"`, `\"`) + `"`
+}
+
+// splitRichText splits rich text at the first occurrence of sep in a text run.
+// The after part is nil when sep does not occur.
+func splitRichText(text []notion.RichText, sep string) (before, after []notion.RichText) {
+	for i, rt := range text {
+		if rt.Type != notion.RichTextTypeText || rt.Text == nil {
+			continue
+		}
+		idx := strings.Index(rt.Text.Content, sep)
+		if idx < 0 {
+			continue
+		}
+		head, tail := rt, rt
+		headText, tailText := *rt.Text, *rt.Text
+		headText.Content = rt.Text.Content[:idx]
+		tailText.Content = rt.Text.Content[idx+len(sep):]
+		head.Text, tail.Text = &headText, &tailText
+
+		before = append(append(before, text[:i]...), head)
+		after = append([]notion.RichText{tail}, text[i+1:]...)
+		return before, after
+	}
+	return text, nil
+}
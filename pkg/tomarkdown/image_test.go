@@ -0,0 +1,303 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/stretchr/testify/assert"
+)
+
+// testJPEG returns the bytes of a small JPEG image of the given width
+func testJPEG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func TestImageConvert(t *testing.T) {
+	dir := t.TempDir()
+	tom := New()
+	tom.ImageConvert = "webp"
+	tom.ImageQuality = 75
+
+	localPath := filepath.Join(dir, "photo.jpg")
+	visitPath, err := tom.saveTo(bytes.NewReader(testJPEG(t, 5, 3)), localPath, "/images/photo.jpg", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "/images/photo.webp", visitPath)
+	assert.NoFileExists(t, localPath)
+
+	content, err := os.ReadFile(filepath.Join(dir, "photo.webp"))
+	assert.NoError(t, err)
+	if assert.Greater(t, len(content), 25) {
+		assert.Equal(t, "RIFF", string(content[:4]))
+		assert.Equal(t, uint32(len(content)-8), binary.LittleEndian.Uint32(content[4:8]))
+		assert.Equal(t, "WEBPVP8L", string(content[8:16]))
+		// the 14-bit width and height minus one follow the 0x2f signature
+		bits := binary.LittleEndian.Uint32(content[21:25])
+		assert.Equal(t, byte(0x2f), content[20])
+		assert.Equal(t, uint32(5), bits&0x3fff+1)
+		assert.Equal(t, uint32(3), bits>>14&0x3fff+1)
+	}
+
+	// other content types are saved as they are
+	visitPath, err = tom.saveTo(strings.NewReader("%PDF-1.4"), filepath.Join(dir, "doc.pdf"), "/images/doc.pdf", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "/images/doc.pdf", visitPath)
+	assert.FileExists(t, filepath.Join(dir, "doc.pdf"))
+}
+
+func TestImageConvertReuse(t *testing.T) {
+	photo := testJPEG(t, 4, 4)
+	dir := t.TempDir()
+	var downloads int
+	for run := 0; run < 2; run++ {
+		tom := New()
+		tom.ImgSavePath = dir
+		tom.ImgVisitPath = "/images"
+		tom.ImageConvert = "WebP"
+		tom.ImageClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			downloads++
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo)), Request: req}, nil
+		})}
+
+		blocks := []notion.Block{{
+			Type:  notion.BlockTypeImage,
+			Image: &notion.FileBlock{Type: notion.FileTypeExternal, External: &notion.FileExternal{URL: "https://img.example.com/photo.jpg"}},
+		}}
+		var out bytes.Buffer
+		assert.NoError(t, tom.GenerateTo(blocks, &out))
+		assert.True(t, strings.HasSuffix(blocks[0].Image.External.URL, ".webp"), blocks[0].Image.External.URL)
+	}
+	assert.Equal(t, 1, downloads, "the converted image of the first run is reused")
+}
+
+func TestWebPCodeLengths(t *testing.T) {
+	// a long tail of rare symbols would need codes longer than 15 bits
+	counts := make([]int, 40)
+	for i := range counts {
+		counts[i] = 1 << uint(i/2)
+	}
+	code := newWebPCode(counts, 15)
+	var kraft float64
+	for _, length := range code.lengths {
+		assert.LessOrEqual(t, int(length), 15)
+		if length > 0 {
+			kraft += 1 / float64(int(1)<<length)
+		}
+	}
+	assert.Equal(t, 1.0, kraft)
+}
+
+func TestMaxImageWidth(t *testing.T) {
+	dir := t.TempDir()
+	tom := New()
+	tom.MaxImageWidth = 800
+
+	var wide bytes.Buffer
+	assert.NoError(t, png.Encode(&wide, image.NewRGBA(image.Rect(0, 0, 2000, 500))))
+	visitPath, err := tom.saveTo(&wide, filepath.Join(dir, "wide.png"), "/images/wide.png", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "/images/wide.png", visitPath)
+
+	f, err := os.Open(filepath.Join(dir, "wide.png"))
+	assert.NoError(t, err)
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 800, cfg.Width)
+	assert.Equal(t, 200, cfg.Height)
+
+	// smaller images keep their exact bytes
+	small := testJPEG(t, 100, 50)
+	_, err = tom.saveTo(bytes.NewReader(small), filepath.Join(dir, "small.jpg"), "/images/small.jpg", dir)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(dir, "small.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, small, content)
+}
+
+func TestImageDeduplication(t *testing.T) {
+	photo := testJPEG(t, 4, 4)
+	tom := New()
+	tom.ImgSavePath = t.TempDir()
+	tom.ImgVisitPath = "/images/post"
+	tom.ImageClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo)), Request: req}, nil
+	})}
+
+	image := func(url string) notion.Block {
+		return notion.Block{
+			Type:  notion.BlockTypeImage,
+			Image: &notion.FileBlock{Type: notion.FileTypeExternal, External: &notion.FileExternal{URL: url}},
+		}
+	}
+	blocks := []notion.Block{image("https://img.example.com/a/photo.jpg"), image("https://cdn.example.com/b/copy.jpg")}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+
+	files, err := os.ReadDir(tom.ImgSavePath)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, tom.ImgVisitPath+"/"+files[0].Name(), blocks[0].Image.External.URL)
+	assert.Equal(t, blocks[0].Image.External.URL, blocks[1].Image.External.URL)
+}
+
+// memoryImageStore keeps images in memory and links them on a CDN
+type memoryImageStore struct {
+	images map[string][]byte
+}
+
+func (s *memoryImageStore) Put(name string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	s.images[name] = data
+	return "https://cdn.example.com/" + name, nil
+}
+
+func TestImageStore(t *testing.T) {
+	photo := testJPEG(t, 4, 4)
+	store := &memoryImageStore{images: make(map[string][]byte)}
+	tom := New()
+	tom.ImgSavePath = filepath.Join(t.TempDir(), "images")
+	tom.ImgVisitPath = "/images/post"
+	tom.ImageStore = store
+	tom.ImageClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo)), Request: req}, nil
+	})}
+
+	blocks := []notion.Block{{
+		Type:  notion.BlockTypeImage,
+		Image: &notion.FileBlock{Type: notion.FileTypeExternal, External: &notion.FileExternal{URL: "https://img.example.com/photo.jpg"}},
+	}}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+
+	name := "img.example.com__photo.jpg_photo.jpg"
+	assert.Equal(t, photo, store.images[name])
+	assert.Contains(t, out.String(), "(https://cdn.example.com/"+name+")")
+	assert.NoDirExists(t, tom.ImgSavePath)
+}
+
+func TestDownloadLimiter(t *testing.T) {
+	photo := testJPEG(t, 4, 4)
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(photo)), Request: req}, nil
+	})}
+
+	limiter := NewDownloadLimiter(2)
+	var wg sync.WaitGroup
+	for page := 0; page < 6; page++ {
+		tom := New()
+		tom.ImgSavePath = t.TempDir()
+		tom.ImgVisitPath = "/images/post"
+		tom.ImageClient = client
+		tom.ImageDownloads = limiter
+		var blocks []notion.Block
+		for i := 0; i < 3; i++ {
+			blocks = append(blocks, notion.Block{
+				Type: notion.BlockTypeImage,
+				Image: &notion.FileBlock{
+					Type:     notion.FileTypeExternal,
+					External: &notion.FileExternal{URL: fmt.Sprintf("https://img.example.com/%d/%d.jpg", page, i)},
+				},
+			})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, tom.GenerateTo(blocks, io.Discard))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, maxInFlight)
+}
+
+func TestKeepRemoteImages(t *testing.T) {
+	tom := New()
+	tom.ImgSavePath = filepath.Join(t.TempDir(), "images")
+	tom.ImgVisitPath = "/images/post"
+	tom.KeepRemoteImages = true
+	tom.ImageClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected image download %s", req.URL)
+		return nil, io.EOF
+	})}
+
+	coverURL := "https://s3.us-west-2.amazonaws.com/secure.notion-static.com/cover.png"
+	tom.WithFrontMatter(notion.Page{Cover: &notion.Cover{Type: notion.FileTypeFile, File: &notion.FileFile{URL: coverURL}}})
+	assert.Equal(t, coverURL, tom.FrontMatter["cover"])
+
+	blocks := []notion.Block{
+		{Type: notion.BlockTypeImage, Image: &notion.FileBlock{Type: notion.FileTypeExternal, External: &notion.FileExternal{URL: "https://img.example.com/photo.jpg"}}},
+		{Type: notion.BlockTypeImage, Image: &notion.FileBlock{Type: notion.FileTypeFile, File: &notion.FileFile{URL: "https://s3.us-west-2.amazonaws.com/secure.notion-static.com/photo.png"}}},
+	}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Contains(t, out.String(), "(https://img.example.com/photo.jpg)")
+	assert.Contains(t, out.String(), "(https://s3.us-west-2.amazonaws.com/secure.notion-static.com/photo.png)")
+	assert.NoDirExists(t, tom.ImgSavePath)
+}
+
+func TestInlineImageMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	tom := New()
+	tom.InlineImageMaxBytes = 1024
+
+	tiny := testJPEG(t, 2, 2)
+	assert.Less(t, len(tiny), tom.InlineImageMaxBytes)
+	visitPath, err := tom.saveTo(bytes.NewReader(tiny), filepath.Join(dir, "tiny.jpg"), "/images/tiny.jpg", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "data:image/jpeg;base64,"+base64.StdEncoding.EncodeToString(tiny), visitPath)
+	assert.NoFileExists(t, filepath.Join(dir, "tiny.jpg"))
+
+	var large bytes.Buffer
+	assert.NoError(t, png.Encode(&large, noiseImage(64, 64)))
+	assert.Greater(t, large.Len(), tom.InlineImageMaxBytes)
+	visitPath, err = tom.saveTo(&large, filepath.Join(dir, "large.png"), "/images/large.png", dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "/images/large.png", visitPath)
+	assert.FileExists(t, filepath.Join(dir, "large.png"))
+}
+
+// noiseImage returns an image that doesn't compress well
+func noiseImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 37), G: uint8(y * 91), B: uint8(x * y * 13), A: 255})
+		}
+	}
+	return img
+}
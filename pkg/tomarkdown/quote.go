@@ -0,0 +1,72 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"strings"
+)
+
+// quoteText prefixes every line of a quote with ">" at the given depth. A
+// last line starting with an em-dash is the attribution and is set apart by
+// an empty quote line.
+func (tm *ToMarkdown) quoteText(text string, depth int) string {
+	lines := strings.Split(text, "\n")
+	if n := len(lines); n > 1 && strings.HasPrefix(strings.TrimSpace(lines[n-1]), "—") {
+		attribution := strings.TrimSpace(lines[n-1])
+		lines = lines[:n-1]
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, "", attribution)
+	}
+	return tm.prefixQuote(strings.Join(lines, "\n"), depth)
+}
+
+// prefixQuote adds one level of "> " to each line, after the indentation of depth.
+func (tm *ToMarkdown) prefixQuote(text string, depth int) string {
+	indent := tm.indent(depth)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line == "" {
+			lines[i] = indent + ">"
+		} else {
+			lines[i] = indent + "> " + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// genQuoteChildren renders the children of a quote inside it, so nested
+// quotes and paragraphs gain one ">" level instead of an indentation.
+func (tm *ToMarkdown) genQuoteChildren(block MdBlock) error {
+	parent := tm.ContentBuffer
+	tm.ContentBuffer = new(bytes.Buffer)
+	err := tm.GenContentBlocks(getChildrenBlocks(block), 0)
+	children := strings.Trim(tm.ContentBuffer.String(), "\n")
+	tm.ContentBuffer = parent
+	if err != nil {
+		return err
+	}
+
+	if children != "" {
+		parent.WriteString(tm.prefixQuote("\n"+children, block.Depth))
+		parent.WriteString("\n")
+	}
+	if attribute := tm.quoteAttribute(block); attribute != "" {
+		parent.WriteString(attribute + "\n")
+	}
+	parent.WriteString("\n")
+	return nil
+}
+
+// quoteAttribute returns the Hugo block attribute setting the colorClass of a
+// quote, written on the line after it, or an empty string for other targets.
+func (tm *ToMarkdown) quoteAttribute(block MdBlock) string {
+	if tm.extra["ExtendedSyntaxTarget"] != "hugo" {
+		return ""
+	}
+	class := tm.colorClass(block)
+	if class == "" {
+		return ""
+	}
+	return tm.indent(block.Depth) + "{." + class + "}"
+}
@@ -0,0 +1,67 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// startsBlockGroup reports whether a top-level block of type bType following
+// one of type last begins a new group, which is set apart by a blank line.
+// Consecutive items of the same list form one group, every other block is a
+// group of its own.
+func startsBlockGroup(bType, last notion.BlockType) bool {
+	switch bType {
+	case notion.BlockTypeBulletedListItem, notion.BlockTypeNumberedListItem, notion.BlockTypeToDo:
+		return bType != last
+	}
+	return true
+}
+
+// separateBlockGroup ends the content written so far with a blank line
+func (tm *ToMarkdown) separateBlockGroup() {
+	content := tm.ContentBuffer.Bytes()
+	if len(content) == 0 || bytes.HasSuffix(content, []byte("\n\n")) {
+		return
+	}
+	if content[len(content)-1] != '\n' {
+		tm.ContentBuffer.WriteString("\n")
+	}
+	tm.ContentBuffer.WriteString("\n")
+}
+
+// normalizeSpacing collapses runs of blank lines outside of fenced code blocks
+// into a single one and ends the document with exactly one newline.
+func normalizeSpacing(markdown string) string {
+	lines := strings.Split(markdown, "\n")
+	out := make([]string, 0, len(lines))
+	fence := ""
+	blank := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			out = append(out, line)
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+		}
+		if trimmed == "" {
+			blank = len(out) > 0
+			continue
+		}
+		if blank {
+			out = append(out, "")
+			blank = false
+		}
+		out = append(out, line)
+	}
+	if len(out) == 0 {
+		return ""
+	}
+	return strings.Join(out, "\n") + "\n"
+}
@@ -0,0 +1,62 @@
+package tomarkdown
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DefaultWordsPerMinute is the reading speed used when WordsPerMinute is unset.
+const DefaultWordsPerMinute = 200
+
+// injectReadingStats sets the "word_count" and "reading_time" (in minutes)
+// front matter fields from the rendered Markdown content.
+func (tm *ToMarkdown) injectReadingStats(content string) {
+	wpm := tm.WordsPerMinute
+	if wpm <= 0 {
+		wpm = DefaultWordsPerMinute
+	}
+	words := countWords(content)
+	tm.FrontMatter["word_count"] = words
+	tm.FrontMatter["reading_time"] = (words + wpm - 1) / wpm
+}
+
+// countWords counts the words of a Markdown document, ignoring the front
+// matter, fenced code blocks and tokens without any letter or digit (like
+// list markers or heading hashes).
+func countWords(markdown string) int {
+	lines := strings.Split(markdown, "\n")
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+		for i := 1; i < len(lines); i++ {
+			if strings.TrimSpace(lines[i]) == "---" {
+				lines = lines[i+1:]
+				break
+			}
+		}
+	}
+
+	count := 0
+	fence := ""
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+		for _, field := range strings.Fields(line) {
+			if strings.IndexFunc(field, isWordRune) >= 0 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,35 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dstotijn/go-notion"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountWords(t *testing.T) {
+	doc := "---\ntitle: Not counted\n---\n# Hello world\n\n- one, two\n- three\n\n```go\nfmt.Println(\"skipped\")\n```\n\n> done.\n"
+	assert.Equal(t, 6, countWords(doc))
+}
+
+func TestReadingStats(t *testing.T) {
+	tom := New()
+	tom.ReadingStats = true
+	tom.WordsPerMinute = 4
+	blocks := []notion.Block{{
+		Type: notion.BlockTypeParagraph,
+		Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{
+			Type: notion.RichTextTypeText,
+			Text: &notion.Text{Content: strings.Repeat("word ", 9)},
+		}}},
+	}}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, 9, tom.FrontMatter["word_count"])
+	assert.Equal(t, 3, tom.FrontMatter["reading_time"])
+	assert.True(t, strings.HasPrefix(out.String(), "---\n"))
+	assert.Contains(t, out.String(), "reading_time: 3\n")
+	assert.Contains(t, out.String(), "word_count: 9\n")
+}
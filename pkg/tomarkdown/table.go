@@ -0,0 +1,39 @@
+package tomarkdown
+
+import (
+	"bytes"
+	"strings"
+)
+
+// DefaultWideTableWrapper scrolls wide tables horizontally in themes styling
+// the table-wrapper class.
+const DefaultWideTableWrapper = "<div class=\"table-wrapper\">\n\n%s\n</div>"
+
+// genTableChildren renders the rows of a table. Tables with more than
+// WideTableColumns columns are put in the WideTableWrapper, in place of its %s
+// or after it when it has none.
+func (tm *ToMarkdown) genTableChildren(block MdBlock) error {
+	if tm.WideTableColumns <= 0 || block.Table.TableWidth <= tm.WideTableColumns {
+		return tm.GenContentBlocks(getChildrenBlocks(block), block.Depth+1)
+	}
+
+	parent := tm.ContentBuffer
+	tm.ContentBuffer = new(bytes.Buffer)
+	err := tm.GenContentBlocks(getChildrenBlocks(block), block.Depth+1)
+	rows := tm.ContentBuffer.String()
+	tm.ContentBuffer = parent
+	if err != nil {
+		return err
+	}
+
+	wrapper := tm.WideTableWrapper
+	if wrapper == "" {
+		wrapper = DefaultWideTableWrapper
+	}
+	if !strings.Contains(wrapper, "%s") {
+		wrapper += "\n%s"
+	}
+	parent.WriteString(strings.Replace(wrapper, "%s", rows, 1))
+	parent.WriteString("\n\n")
+	return nil
+}
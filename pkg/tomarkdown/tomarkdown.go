@@ -3,8 +3,10 @@ package tomarkdown
 import (
 	"bytes"
 	"embed"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
@@ -12,11 +14,10 @@ import (
 	"strings"
 	"text/template"
 	"time"
+	"unicode"
 
 	"github.com/Masterminds/sprig"
 	"github.com/dstotijn/go-notion"
-	"github.com/otiai10/opengraph"
-	"gopkg.in/yaml.v3"
 )
 
 //go:embed templates
@@ -37,10 +38,42 @@ var (
 	}
 )
 
+// CustomSyntaxTarget is the extended syntax target whose templates are read
+// from ShortcodeTemplateDir.
+const CustomSyntaxTarget = "custom"
+
+// ExtendedSyntaxTargets are the targets supported by EnableExtendedSyntax.
+var ExtendedSyntaxTargets = []string{"hugo", "hexo", "vuepress", CustomSyntaxTarget}
+
+// DateFormat is the layout of dates written to the front matter, RFC 3339 with
+// the offset of the date.
+const DateFormat = time.RFC3339
+
+// DefaultIndentUnit indents nested blocks when IndentUnit is not set. It is four
+// spaces rather than two: CommonMark nests a block under a numbered list item
+// only when it is indented past the "1. " marker, which two spaces are not.
+const DefaultIndentUnit = "    "
+
+// Supported values for ToMarkdown.LinkStyle.
+const (
+	LinkStyleInline    = "inline"
+	LinkStyleReference = "reference"
+)
+
 type MdBlock struct {
 	notion.Block
 	Depth int
 	Extra map[string]interface{}
+
+	// DatabasePages of a child database, see ToMarkdown.LinkedDatabaseRows
+	DatabasePages []DatabasePage
+
+	// Body is the rendered content of a callout shortcode or a toggle, written
+	// inside the block
+	Body string
+
+	// children of blocks whose Notion type has no Children field (toggleable headings)
+	children []notion.Block
 }
 
 type ToMarkdown struct {
@@ -49,25 +82,225 @@ type ToMarkdown struct {
 	ImgSavePath     string
 	ImgVisitPath    string
 	ContentTemplate string
+	// WrapperTemplate names a template wrapping the body of every page, e.g.
+	// to add a license footer. It receives the body as .Content, the output
+	// of the ContentTemplate if any, and the front matter fields as
+	// .FrontMatter. The front matter stays above the wrapper output unless
+	// the ContentTemplate places it, which makes it part of the body.
+	WrapperTemplate string
+	// TemplateDir holds block templates (e.g. paragraph.gohtml) that override
+	// the embedded ones. Block types without a file there use the embedded template.
+	TemplateDir string
+	// ShortcodeTemplateDir holds the block templates of the CustomSyntaxTarget,
+	// e.g. callout.gohtml. They take precedence over the embedded templates,
+	// those in TemplateDir over them.
+	ShortcodeTemplateDir string
+	// LinkStyle selects how links are written: inline (default) or reference.
+	LinkStyle string
+	// InternalLinkStyle selects how links to exported pages and downloaded
+	// images are written: markdown (default) or wikilink, the [[Page]] and
+	// ![[image.png]] links of Obsidian.
+	InternalLinkStyle string
+	// EscapeMarkdown escapes Markdown-significant characters in plain text runs.
+	EscapeMarkdown bool
+	// IndentUnit is the indentation of one level of nested blocks, e.g. a tab.
+	// DefaultIndentUnit is used when it is empty.
+	IndentUnit string
+	// FetchBlockChildren retrieves the children of a block by ID. It is used to
+	// resolve synced blocks that reference another block; when nil, such
+	// references render nothing.
+	FetchBlockChildren func(blockID string) ([]notion.Block, error)
+	// ColumnWidthRatio returns the share of the row taken by a column, the
+	// width_ratio of newer Notion API responses. Columns share the row equally
+	// when it is nil or has no ratio for a column.
+	ColumnWidthRatio func(columnID string) (float64, bool)
+	// BlockColor returns the Notion color of a callout or quote, e.g.
+	// red_background, which go-notion doesn't decode. Extended syntax passes
+	// it on as a CSS class, see colorClass.
+	BlockColor func(blockID string) (string, bool)
+	// ImageStore saves the downloaded images and returns their URLs. Images
+	// are written to ImgSavePath and linked below ImgVisitPath when it is nil.
+	ImageStore ImageStore
+	// ImageClient is the HTTP client used to download images. New sets a client
+	// that honors the HTTP_PROXY/HTTPS_PROXY environment and times out.
+	ImageClient *http.Client
+	// BookmarkClient fetches the opengraph metadata of bookmarks. New sets a
+	// client that times out, a failed lookup renders the bookmark as a bare link.
+	BookmarkClient *http.Client
+	// BookmarkCache, when set, reuses the metadata of already fetched bookmarks
+	BookmarkCache *BookmarkCache
+	// EquationNumbering numbers the equation blocks of a page with \tag{n}
+	EquationNumbering bool
+	// InlineImageMaxBytes embeds images of up to this size as data: URIs
+	// instead of saving them to ImgSavePath (0 disables it).
+	InlineImageMaxBytes int
+	// ImageTitleSeparator splits image captions into the alt text before and
+	// the image title after it, e.g. "|". Empty uses the whole caption as alt text.
+	ImageTitleSeparator string
+	// ImageIndex links images with identical content to the file saved first.
+	// New sets an index per converter, share one index between converters to
+	// save an image used by several pages once.
+	ImageIndex *ImageIndex
+	// ImageDownloads bounds the concurrent image downloads, share one limiter
+	// between converters to bound a whole export. Nil doesn't limit them.
+	ImageDownloads *DownloadLimiter
+	// KeepRemoteImages leaves image and cover URLs pointing at their source
+	// instead of downloading the files.
+	KeepRemoteImages bool
+	// ImageConvert transcodes downloaded PNG and JPEG images to this format:
+	// jpeg, png, webp or one added with RegisterImageEncoder. Empty keeps the
+	// original files.
+	ImageConvert string
+	// ImageQuality is the encoding quality used by ImageConvert (1-100,
+	// DefaultImageQuality when 0). WebP images are lossless at 90 and above.
+	ImageQuality int
+	// MaxImageWidth downscales wider PNG and JPEG images to this width (0 disables)
+	MaxImageWidth int
+	// ReadingStats adds "word_count" and "reading_time" front matter fields
+	ReadingStats bool
+	// WordsPerMinute is the reading speed for ReadingStats (DefaultWordsPerMinute when 0)
+	WordsPerMinute int
+	// LastmodField is the front matter field set to the page's last edited time
+	// by WithFrontMatter (empty disables it).
+	LastmodField string
+	// PageIDField is the front matter field set to the page's dashed Notion ID
+	// by WithFrontMatter, e.g. notion_id (empty disables it).
+	PageIDField string
+	// CreatedByField and LastEditedByField are the front matter fields set by
+	// WithFrontMatter to the names of the users who created and last edited
+	// the page, as returned by PageEditors (empty disables them).
+	CreatedByField    string
+	LastEditedByField string
+	// PageEditors returns the names of the users who created and last edited
+	// a page, empty when unknown.
+	PageEditors func(pageID string) (createdBy, lastEditedBy string)
+	// FrontMatterDefaults are added to the front matter by WithFrontMatter for
+	// every field the page itself doesn't set, e.g. layout: post.
+	FrontMatterDefaults map[string]interface{}
+	// DateRanges writes date properties with an end date as two fields,
+	// start_<name> and end_<name>, instead of only the start date.
+	DateRanges bool
+	// FrontMatterMapping renames Notion properties in the front matter, e.g.
+	// PublishDate: date. Property names match case-insensitively, properties
+	// mapped to an empty key are left out and unmapped ones keep their name.
+	FrontMatterMapping map[string]string
+	// DisableFrontMatter leaves the front matter out of the output. The
+	// FrontMatter fields are still filled for a ContentTemplate.
+	DisableFrontMatter bool
+	// FrontMatterFormat is FrontMatterYAML (the default when empty),
+	// FrontMatterTOML or FrontMatterJSON.
+	FrontMatterFormat string
+	// CodeCaption selects how the caption of code blocks is written:
+	// CodeCaptionLine, CodeCaptionTitle or not at all when empty.
+	CodeCaption string
+	// WideTableColumns is the column count above which tables are wrapped in
+	// WideTableWrapper (0 disables the wrapping).
+	WideTableColumns int
+	// WideTableWrapper surrounds wide tables, %s marks the table
+	// (DefaultWideTableWrapper when empty).
+	WideTableWrapper string
+	// PlainFallback renders callouts as blockquotes and bookmarks as links in
+	// plain Markdown when extended syntax is disabled, instead of leaving them out.
+	PlainFallback bool
+	// PlainBookmarkTitles fetches the title of bookmarks rendered by
+	// PlainFallback, which otherwise link with their bare URL.
+	PlainBookmarkTitles bool
+	// ToggleHeadingDetails wraps the content of toggleable headings in <details>
+	ToggleHeadingDetails bool
+	// ContinuousNumbering continues a numbered list after empty paragraphs
+	// instead of starting over at 1, for lists split by an empty line.
+	ContinuousNumbering bool
+	// SyncedBlockMarkers wraps the content of synced blocks in
+	// <!-- synced-block: <id> --> comments, the ID of the original block.
+	SyncedBlockMarkers bool
+	// PageLinkResolver returns the link to the generated file of a Notion page or
+	// database, and false when the target is not part of the export.
+	PageLinkResolver func(pageID string) (string, bool)
+	// PageTitleResolver returns the title of a Notion page or database for
+	// link_to_page blocks, which only carry the target ID.
+	PageTitleResolver func(pageID string) (string, bool)
+	// LinkedDatabaseRows lists up to this many pages of a child database below
+	// its title, queried with QueryDatabasePages (0 writes the title only).
+	LinkedDatabaseRows int
+	// QueryDatabasePages returns the first limit pages of a database
+	QueryDatabasePages func(databaseID string, limit int) ([]DatabasePage, error)
+	// PostProcess, when set, transforms the rendered block content before it
+	// is written or passed to the ContentTemplate. An error aborts GenerateTo.
+	PostProcess func(content string) (string, error)
+	// Warn, when set, receives the problems that don't fail the page, like an
+	// image that is gone and stays linked to its remote URL.
+	Warn func(message string)
 
-	extra map[string]interface{}
+	extra      map[string]interface{}
+	linkRefs   []string
+	linkRefIdx map[string]int
+	headings   []Heading
+	equations  int
+	// the page passed to WithFrontMatter, named in errors of GenerateTo
+	pageID    string
+	pageTitle string
+}
+
+const (
+	// defaultImageTimeout bounds a single image download
+	defaultImageTimeout = 60 * time.Second
+	// defaultBookmarkTimeout bounds the opengraph lookup of a bookmark
+	defaultBookmarkTimeout = 10 * time.Second
+)
+
+// newHTTPClient returns a client honoring the proxy environment that gives
+// up on a request after timeout.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	return &http.Client{Transport: transport, Timeout: timeout}
 }
 
 func New() *ToMarkdown {
 	return &ToMarkdown{
-		FrontMatter:   make(map[string]interface{}),
-		ContentBuffer: new(bytes.Buffer),
-		extra:         make(map[string]interface{}),
+		FrontMatter:    make(map[string]interface{}),
+		ContentBuffer:  new(bytes.Buffer),
+		LinkStyle:      LinkStyleInline,
+		EscapeMarkdown: true,
+		ImageClient:    newHTTPClient(defaultImageTimeout),
+		BookmarkClient: newHTTPClient(defaultBookmarkTimeout),
+		ImageIndex:     NewImageIndex(),
+		LastmodField:   "lastmod",
+		extra:          make(map[string]interface{}),
+		linkRefIdx:     make(map[string]int),
 	}
 }
 
 // WithFrontMatter loads data from the Notion page (like cover or custom properties)
 // into the front matter map.
 func (tm *ToMarkdown) WithFrontMatter(page notion.Page) {
+	tm.pageID = page.ID
+	tm.pageTitle = pageTitle(page)
 	tm.injectFrontMatterCover(page.Cover)
-	pageProps := page.Properties.(notion.DatabasePageProperties)
+	if tm.LastmodField != "" && !page.LastEditedTime.IsZero() {
+		tm.FrontMatter[tm.LastmodField] = page.LastEditedTime.Format(DateFormat)
+	}
+	if tm.PageIDField != "" && page.ID != "" {
+		tm.FrontMatter[tm.PageIDField] = dashedID(page.ID)
+	}
+	tm.injectPageEditors(page.ID)
+	// pages outside of a database have no custom properties
+	pageProps, _ := page.Properties.(notion.DatabasePageProperties)
+	mapping := tm.frontMatterMapping()
 	for fmKey, property := range pageProps {
-		tm.injectFrontMatter(fmKey, property)
+		if key, mapped := mapping.key(fmKey); mapped {
+			if key != "" {
+				tm.injectFrontMatter(key, property)
+			}
+		} else if !mapping.isTarget(fmKey) {
+			// properties renamed to this key take its place
+			tm.injectFrontMatter(fmKey, property)
+		}
+	}
+	for key, value := range tm.FrontMatterDefaults {
+		if _, ok := tm.FrontMatter[key]; !ok {
+			tm.FrontMatter[key] = value
+		}
 	}
 }
 
@@ -87,42 +320,134 @@ func (tm *ToMarkdown) ExtendedSyntaxEnabled() bool {
 }
 
 // shouldSkipRender returns true if the given block type should be ignored
-// unless we've explicitly enabled extended syntax
+// unless we've explicitly enabled extended syntax. Template buttons are
+// always ignored, their content only exists to be copied in the editor.
 func (tm *ToMarkdown) shouldSkipRender(bType notion.BlockType) bool {
-	return !tm.ExtendedSyntaxEnabled() && blockTypeInExtendedSyntaxBlocks(bType)
+	if bType == notion.BlockTypeTemplate {
+		return true
+	}
+	if tm.ExtendedSyntaxEnabled() || (tm.PlainFallback && (bType == notion.BlockTypeCallout || bType == notion.BlockTypeBookmark)) {
+		return false
+	}
+	return blockTypeInExtendedSyntaxBlocks(bType)
 }
 
 // GenerateTo renders the blocks into Markdown, writing front matter first (if any),
-// then the block content into the provided writer.
+// then the block content into the provided writer. With a ContentTemplate the
+// template output is written instead of the block content, and a
+// WrapperTemplate surrounds either. Errors name the page passed to
+// WithFrontMatter, if any.
 func (tm *ToMarkdown) GenerateTo(blocks []notion.Block, writer io.Writer) error {
-	// front matter
-	if err := tm.GenFrontMatter(writer); err != nil {
-		return err
+	err := tm.generateTo(blocks, writer)
+	if err != nil && tm.pageID != "" {
+		return fmt.Errorf("page %q (%s): %w", tm.pageTitle, tm.pageID, err)
 	}
+	return err
+}
 
-	// block content
+func (tm *ToMarkdown) generateTo(blocks []notion.Block, writer io.Writer) error {
+	// block content, rendered first so the front matter can describe it
+	tm.linkRefs = nil
+	tm.linkRefIdx = make(map[string]int)
+	tm.headings = nil
+	tm.equations = 0
+	blocks, err := tm.extractFrontMatterBlock(blocks)
+	if err != nil {
+		return err
+	}
 	if err := tm.GenContentBlocks(blocks, 0); err != nil {
 		return err
 	}
+	tm.genLinkReferences()
+	content := normalizeSpacing(tm.ContentBuffer.String())
+	if tm.PostProcess != nil {
+		if content, err = tm.PostProcess(content); err != nil {
+			return fmt.Errorf("post-processing content: %w", err)
+		}
+	}
+	tm.ContentBuffer.Reset()
+	tm.ContentBuffer.WriteString(content)
+	if tm.ReadingStats {
+		tm.injectReadingStats(tm.ContentBuffer.String())
+	}
 
 	// If a custom ContentTemplate is provided, run the final content through that template
+	body := tm.ContentBuffer
+	frontMatterPlaced := false
 	if tm.ContentTemplate != "" {
-		t, err := template.ParseFiles(tm.ContentTemplate)
-		if err != nil {
+		if body, frontMatterPlaced, err = tm.genContentTemplate(); err != nil {
+			return err
+		}
+	}
+	if tm.WrapperTemplate != "" {
+		if body, err = tm.genWrapperTemplate(body.String()); err != nil {
 			return err
 		}
-		return t.Execute(writer, tm)
 	}
 
-	// Otherwise, just copy from the buffer
-	_, err := io.Copy(writer, tm.ContentBuffer)
+	// front matter
+	if !frontMatterPlaced {
+		if err := tm.GenFrontMatter(writer); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(writer, body)
 	return err
 }
 
-// GenFrontMatter marshals any front matter set in tm.FrontMatter to YAML and
-// writes it at the top of the file under triple-dashed lines.
+// genContentTemplate executes the ContentTemplate with tm as data: the
+// rendered blocks are in .ContentBuffer and the front matter fields in
+// .FrontMatter. Besides the block template functions it can call
+// {{ frontMatter }} to place the front matter block itself, which is
+// reported by frontMatterPlaced.
+func (tm *ToMarkdown) genContentTemplate() (content *bytes.Buffer, frontMatterPlaced bool, err error) {
+	funcs := tm.templateFuncs()
+	funcs["frontMatter"] = func() (string, error) {
+		frontMatterPlaced = true
+		var fm bytes.Buffer
+		err := tm.GenFrontMatter(&fm)
+		return fm.String(), err
+	}
+	t, err := template.New(filepath.Base(tm.ContentTemplate)).
+		Funcs(funcs).
+		ParseFiles(tm.ContentTemplate)
+	if err != nil {
+		return nil, false, err
+	}
+
+	content = new(bytes.Buffer)
+	if err := t.Execute(content, tm); err != nil {
+		return nil, false, err
+	}
+	return content, frontMatterPlaced, nil
+}
+
+// genWrapperTemplate executes the WrapperTemplate around the page body
+func (tm *ToMarkdown) genWrapperTemplate(body string) (*bytes.Buffer, error) {
+	t, err := template.New(filepath.Base(tm.WrapperTemplate)).
+		Funcs(tm.templateFuncs()).
+		ParseFiles(tm.WrapperTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Content     string
+		FrontMatter map[string]interface{}
+	}{body, tm.FrontMatter}
+	var content bytes.Buffer
+	if err := t.Execute(&content, data); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// GenFrontMatter marshals any front matter set in tm.FrontMatter in the
+// FrontMatterFormat and writes it at the top of the file between the fences
+// of that format, unless DisableFrontMatter is set.
 func (tm *ToMarkdown) GenFrontMatter(writer io.Writer) error {
-	if len(tm.FrontMatter) == 0 {
+	if tm.DisableFrontMatter || len(tm.FrontMatter) == 0 {
 		return nil
 	}
 	nfm := make(map[string]interface{})
@@ -130,15 +455,15 @@ func (tm *ToMarkdown) GenFrontMatter(writer io.Writer) error {
 		nfm[strings.ToLower(key)] = value
 	}
 
-	frontMatters, err := yaml.Marshal(nfm)
+	fence, frontMatters, err := marshalFrontMatter(tm.FrontMatterFormat, nfm)
 	if err != nil {
 		return nil
 	}
 
 	buffer := new(bytes.Buffer)
-	buffer.WriteString("---\n")
+	buffer.WriteString(fence)
 	buffer.Write(frontMatters)
-	buffer.WriteString("---\n\n")
+	buffer.WriteString(fence + "\n")
 	_, err = io.Copy(writer, buffer)
 	return err
 }
@@ -150,10 +475,17 @@ func (tm *ToMarkdown) GenContentBlocks(blocks []notion.Block, depth int) error {
 	var sameBlockIdx int
 	var lastBlockType notion.BlockType
 
-	for _, block := range blocks {
+	for i, block := range blocks {
 		if tm.shouldSkipRender(block.Type) {
 			continue
 		}
+		if tm.ContinuousNumbering && lastBlockType == notion.BlockTypeNumberedListItem &&
+			isEmptyParagraph(block) && continuesNumberedList(blocks[i+1:]) {
+			continue
+		}
+		if depth == 0 && startsBlockGroup(block.Type, lastBlockType) {
+			tm.separateBlockGroup()
+		}
 		sameBlockIdx++
 		if block.Type != lastBlockType {
 			sameBlockIdx = 0
@@ -176,6 +508,19 @@ func (tm *ToMarkdown) GenContentBlocks(blocks []notion.Block, depth int) error {
 			if err := tm.injectBookmarkInfo(block.Bookmark, &mdb.Extra); err != nil {
 				return err
 			}
+		case notion.BlockTypeSyncedBlock:
+			if err := tm.resolveSyncedBlock(&mdb.Block); err != nil {
+				return err
+			}
+		case notion.BlockTypeChildDatabase:
+			if err := tm.resolveDatabasePages(&mdb); err != nil {
+				return err
+			}
+		case notion.BlockTypeHeading1, notion.BlockTypeHeading2, notion.BlockTypeHeading3:
+			tm.collectHeading(block)
+			if err := tm.resolveHeadingChildren(&mdb); err != nil {
+				return err
+			}
 		}
 
 		// Render the block
@@ -188,13 +533,186 @@ func (tm *ToMarkdown) GenContentBlocks(blocks []notion.Block, depth int) error {
 	return nil
 }
 
+// isEmptyParagraph reports whether block is a paragraph of whitespace only
+func isEmptyParagraph(block notion.Block) bool {
+	return block.Type == notion.BlockTypeParagraph && block.Paragraph != nil && !block.HasChildren &&
+		strings.TrimSpace(plainText(block.Paragraph.Text)) == ""
+}
+
+// continuesNumberedList reports whether the first block after any empty
+// paragraphs is a numbered list item
+func continuesNumberedList(blocks []notion.Block) bool {
+	for _, block := range blocks {
+		if !isEmptyParagraph(block) {
+			return block.Type == notion.BlockTypeNumberedListItem
+		}
+	}
+	return false
+}
+
 // GenBlock executes the relevant template for the block type, appending
 // the output to tm.ContentBuffer. If block.HasChildren, we recursively process
 // its child blocks, at (depth+1).
 func (tm *ToMarkdown) GenBlock(bType notion.BlockType, block MdBlock) error {
+	tplName := fmt.Sprintf("%s.gohtml", bType)
+	t := template.New(tplName).Funcs(tm.templateFuncs())
+
+	var tpl *template.Template
+	var err error
+	if override := tm.templateOverride(tplName); override != "" {
+		if tpl, err = t.ParseFiles(override); err != nil {
+			return err
+		}
+	} else if _, err := fs.Stat(mdTemplatesFS, "templates/"+tplName); err != nil {
+		// If no template for that block type, skip gracefully
+		return nil
+	} else if tpl, err = t.ParseFS(mdTemplatesFS, "templates/"+tplName); err != nil {
+		return err
+	}
+
+	// Callout shortcodes and toggles write their children inside the block
+	var inBody bool
+	switch {
+	case bType == notion.BlockTypeCallout && tm.ExtendedSyntaxEnabled():
+		inBody = true
+		if block.HasChildren {
+			block.Body, err = tm.childrenBody(block, 0)
+		}
+	case bType == notion.BlockTypeToggle:
+		inBody = true
+		if block.HasChildren {
+			block.Body, err = tm.childrenBody(block, block.Depth)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tpl.Execute(tm.ContentBuffer, block); err != nil {
+		return err
+	}
+
+	if inBody {
+		return nil
+	}
+	if bType == notion.BlockTypeColumnList || bType == notion.BlockTypeColumn {
+		return tm.genColumnChildren(block)
+	}
+
+	// If the block has child blocks, render them now at depth+1
+	if block.HasChildren {
+		if bType == notion.BlockTypeQuote || (bType == notion.BlockTypeCallout && !tm.ExtendedSyntaxEnabled()) {
+			return tm.genQuoteChildren(block)
+		}
+		if isHeading(bType) {
+			return tm.genHeadingChildren(block)
+		}
+		if bType == notion.BlockTypeSyncedBlock {
+			return tm.genSyncedChildren(block)
+		}
+		if bType == notion.BlockTypeTable {
+			return tm.genTableChildren(block)
+		}
+		if err := tm.GenContentBlocks(getChildrenBlocks(block), block.Depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isHeading(bType notion.BlockType) bool {
+	return bType == notion.BlockTypeHeading1 || bType == notion.BlockTypeHeading2 || bType == notion.BlockTypeHeading3
+}
+
+// resolveHeadingChildren fetches the content of a toggleable heading. The
+// Notion heading type has no Children field, so they are kept on the MdBlock.
+func (tm *ToMarkdown) resolveHeadingChildren(block *MdBlock) error {
+	if !block.HasChildren || block.children != nil || tm.FetchBlockChildren == nil {
+		return nil
+	}
+	children, err := tm.FetchBlockChildren(block.ID)
+	if err != nil {
+		return fmt.Errorf("fetching heading children %s: %w", block.ID, err)
+	}
+	block.children = children
+	return nil
+}
+
+// genHeadingChildren renders the content of a toggleable heading below it at
+// the heading's depth, inside <details> when ToggleHeadingDetails is set.
+func (tm *ToMarkdown) genHeadingChildren(block MdBlock) error {
+	if len(block.children) == 0 {
+		return nil
+	}
+	tm.ContentBuffer.WriteString("\n")
+	if tm.ToggleHeadingDetails {
+		tm.ContentBuffer.WriteString("<details>\n\n")
+	}
+	if err := tm.GenContentBlocks(block.children, block.Depth); err != nil {
+		return err
+	}
+	if tm.ToggleHeadingDetails {
+		tm.ContentBuffer.WriteString("</details>\n\n")
+	}
+	return nil
+}
+
+// genSyncedChildren renders the content of a synced block at the block's own
+// depth, synced blocks being invisible containers. With SyncedBlockMarkers the
+// content is wrapped in comments naming the original block, so every copy of
+// the same synced content carries the same ID.
+func (tm *ToMarkdown) genSyncedChildren(block MdBlock) error {
+	id := block.ID
+	if from := block.SyncedBlock.SyncedFrom; from != nil && from.BlockID != "" {
+		id = from.BlockID
+	}
+	indent := tm.indent(block.Depth)
+	if tm.SyncedBlockMarkers {
+		fmt.Fprintf(tm.ContentBuffer, "%s<!-- synced-block: %s -->\n\n", indent, id)
+	}
+	if err := tm.GenContentBlocks(block.SyncedBlock.Children, block.Depth); err != nil {
+		return err
+	}
+	if tm.SyncedBlockMarkers {
+		fmt.Fprintf(tm.ContentBuffer, "%s<!-- /synced-block: %s -->\n\n", indent, id)
+	}
+	return nil
+}
+
+// indent returns the indentation of blocks nested depth levels deep
+func (tm *ToMarkdown) indent(depth int) string {
+	unit := tm.IndentUnit
+	if unit == "" {
+		unit = DefaultIndentUnit
+	}
+	return strings.Repeat(unit, depth)
+}
+
+// templateFuncs returns the functions available to block and content templates:
+// the sprig functions plus the converter helpers.
+func (tm *ToMarkdown) templateFuncs() template.FuncMap {
 	funcs := sprig.TxtFuncMap()
-	funcs["deref"] = func(i *bool) bool { return *i }
-	funcs["rich2md"] = ConvertRichText
+	funcs["slugify"] = slugify
+	funcs["deref"] = func(i *bool) bool { return i != nil && *i }
+	funcs["rich2md"] = tm.convertRichText
+	funcs["pageLink"] = tm.pageLink
+	funcs["wikilinks"] = tm.wikilinks
+	funcs["wikilink"] = wikilink
+	funcs["imageEmbed"] = tm.imageEmbed
+	funcs["linkToPage"] = tm.linkToPage
+	funcs["databasePage"] = tm.databasePage
+	funcs["indent"] = tm.indent
+	funcs["quoteText"] = tm.quoteText
+	funcs["plainCallout"] = tm.plainCallout
+	funcs["calloutEmoji"] = calloutEmoji
+	funcs["colorClass"] = tm.colorClass
+	funcs["quoteAttribute"] = tm.quoteAttribute
+	funcs["codeInfo"] = tm.codeInfo
+	funcs["imageAlt"] = tm.imageAlt
+	funcs["equationTag"] = tm.equationTag
+	funcs["columnFlex"] = tm.columnFlex
+	funcs["imageTitle"] = tm.imageTitle
+	funcs["codeCaptionLine"] = tm.codeCaptionLine
 	funcs["indentCode"] = func(richText []notion.RichText, depth int) string {
 		// Get the content without any manipulation
 		content := ConvertRichText(richText)
@@ -205,7 +723,7 @@ func (tm *ToMarkdown) GenBlock(bType notion.BlockType, block MdBlock) error {
 		}
 
 		// Apply indentation based on depth
-		indent := strings.Repeat("  ", depth)
+		indent := tm.indent(depth)
 
 		// Split into lines for processing
 		lines := strings.Split(content, "\n")
@@ -225,7 +743,7 @@ func (tm *ToMarkdown) GenBlock(bType notion.BlockType, block MdBlock) error {
 			return content
 		}
 
-		indent := strings.Repeat("  ", depth)
+		indent := tm.indent(depth)
 		lines := strings.Split(content, "\n")
 		for i := 0; i < len(lines); i++ {
 			lines[i] = indent + lines[i]
@@ -233,44 +751,161 @@ func (tm *ToMarkdown) GenBlock(bType notion.BlockType, block MdBlock) error {
 
 		return strings.Join(lines, "\n")
 	}
+	return funcs
+}
 
-	tplName := fmt.Sprintf("%s.gohtml", bType)
-	t := template.New(tplName).Funcs(funcs)
+// linkToPage renders a link_to_page block as a link to the exported page. When
+// the target is not part of the export it links to the page on Notion, and
+// the page ID is used as text unless the PageTitleResolver knows the title.
+func (tm *ToMarkdown) linkToPage(target *notion.LinkToPage) string {
+	id := target.PageID
+	if target.Type == notion.LinkToPageTypeDatabaseID {
+		id = target.DatabaseID
+	}
+	title := id
+	if tm.PageTitleResolver != nil {
+		if resolved, ok := tm.PageTitleResolver(id); ok && resolved != "" {
+			title = resolved
+		}
+	}
+	link := tm.pageLink(id)
+	if link != "" && tm.wikilinks() {
+		return wikilink(title, link)
+	}
+	if tm.EscapeMarkdown && title != id {
+		title = markdownEscaper.Replace(title)
+	}
+	if link == "" {
+		link = "https://www.notion.so/" + strings.ReplaceAll(id, "-", "")
+	}
+	return tm.formatLink(title, link)
+}
 
-	tpl, err := t.ParseFS(mdTemplatesFS, "templates/"+tplName)
-	if err != nil {
-		// If no template for that block type, skip gracefully
-		return nil
+// pageTitle returns the plain text of the title property of a database page
+func pageTitle(page notion.Page) string {
+	props, _ := page.Properties.(notion.DatabasePageProperties)
+	for _, prop := range props {
+		if prop.Type == notion.DBPropTypeTitle {
+			return plainText(prop.Title)
+		}
 	}
+	return ""
+}
 
-	if err := tpl.Execute(tm.ContentBuffer, block); err != nil {
-		return err
+// plainText returns rich text without any formatting
+func plainText(richText []notion.RichText) string {
+	var b strings.Builder
+	for _, rt := range richText {
+		if rt.Text != nil {
+			b.WriteString(rt.Text.Content)
+		} else {
+			b.WriteString(rt.PlainText)
+		}
 	}
+	return b.String()
+}
 
-	// If the block has child blocks, render them now at depth+1
-	if block.HasChildren {
-		if err := tm.GenContentBlocks(getChildrenBlocks(block), block.Depth+1); err != nil {
-			return err
+// slugify lowercases s and joins its runs of letters and digits with dashes,
+// e.g. "Hello, World!" becomes "hello-world".
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
 		}
 	}
+	return b.String()
+}
+
+// pageLink returns the link of an exported page, or an empty string when the
+// page is unknown to the PageLinkResolver
+func (tm *ToMarkdown) pageLink(pageID string) string {
+	if tm.PageLinkResolver == nil {
+		return ""
+	}
+	if link, ok := tm.PageLinkResolver(pageID); ok {
+		return link
+	}
+	return ""
+}
+
+// resolveSyncedBlock loads the content of the original block for a synced block
+// that references another block, so the synced content appears in the output.
+func (tm *ToMarkdown) resolveSyncedBlock(block *notion.Block) error {
+	synced := block.SyncedBlock
+	if synced == nil || synced.SyncedFrom == nil || len(synced.Children) > 0 || tm.FetchBlockChildren == nil {
+		return nil
+	}
+	children, err := tm.FetchBlockChildren(synced.SyncedFrom.BlockID)
+	if err != nil {
+		return fmt.Errorf("fetching synced block %s: %w", synced.SyncedFrom.BlockID, err)
+	}
+	synced.Children = children
+	block.HasChildren = len(children) > 0
 	return nil
 }
 
+// warnf passes a problem that doesn't fail the page to Warn
+func (tm *ToMarkdown) warnf(format string, args ...interface{}) {
+	if tm.Warn != nil {
+		tm.Warn(fmt.Sprintf(format, args...))
+	}
+}
+
 // downloadImage fetches the external image or file-based image, saves it locally, and updates its URL
 func (tm *ToMarkdown) downloadImage(image *notion.FileBlock) error {
+	if tm.KeepRemoteImages {
+		return nil
+	}
 	download := func(imgURL string) (string, error) {
 		localPath, visitPath, err := tm.buildImagePaths(imgURL, tm.ImgSavePath)
 		if err != nil {
 			return "", err
 		}
-		if _, err := os.Stat(localPath); err == nil {
-			return visitPath, nil
+		// images saved by earlier runs are reused, unless they go to an ImageStore
+		if tm.ImageStore == nil {
+			if info, err := os.Stat(localPath); err == nil {
+				if tm.InlineImageMaxBytes > 0 && info.Size() <= int64(tm.InlineImageMaxBytes) {
+					// saved by an earlier run without inlining
+					if data, err := os.ReadFile(localPath); err == nil {
+						dataURI, _ := tm.inlineImage(data, visitPath)
+						return dataURI, nil
+					}
+				}
+				return visitPath, nil
+			}
+			if format := strings.ToLower(tm.ImageConvert); format != "" {
+				if _, err := os.Stat(replaceExt(localPath, format)); err == nil {
+					return replaceExt(visitPath, format), nil
+				}
+			}
+		}
+		client := tm.ImageClient
+		if client == nil {
+			client = http.DefaultClient
 		}
-		resp, err := http.Get(imgURL)
+		tm.ImageDownloads.acquire()
+		defer tm.ImageDownloads.release()
+		resp, err := client.Get(imgURL)
 		if err != nil {
 			return "", err
 		}
 		defer resp.Body.Close()
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			// the image is gone for good, keep linking to it instead of failing the page
+			tm.warnf("skipping image %s: %s", imgURL, resp.Status)
+			return imgURL, nil
+		}
+		if resp.StatusCode >= 300 {
+			return "", fmt.Errorf("downloading image %s: %s", imgURL, resp.Status)
+		}
 		return tm.saveTo(resp.Body, localPath, visitPath, tm.ImgSavePath)
 	}
 
@@ -311,74 +946,135 @@ func (tm *ToMarkdown) buildImagePaths(rawURL, distDir string) (string, string, e
 	return filepath.Join(distDir, filename), filepath.Join(tm.ImgVisitPath, filename), nil
 }
 
-// saveTo saves the content of reader into distDir and returns the final public path.
+// saveTo saves the content of reader into distDir, or the ImageStore when set,
+// and returns the final public path.
+// Content already saved according to the ImageIndex is not written again.
 func (tm *ToMarkdown) saveTo(reader io.Reader, localPath, visitPath, distDir string) (string, error) {
-	if err := os.MkdirAll(distDir, 0755); err != nil {
-		return "", fmt.Errorf("%s: %s", distDir, err)
-	}
-	out, err := os.Create(localPath)
+	data, err := io.ReadAll(reader)
 	if err != nil {
-		return "", fmt.Errorf("couldn't create image file: %s", err)
+		return "", err
 	}
-	defer out.Close()
+	if tm.ImageIndex == nil {
+		return tm.writeImage(data, localPath, visitPath, distDir)
+	}
+	return tm.ImageIndex.save(contentHash(data), func() (string, error) {
+		return tm.writeImage(data, localPath, visitPath, distDir)
+	})
+}
 
-	_, err = io.Copy(out, reader)
-	if err != nil {
-		return "", err
+// writeImage saves data like saveTo, without looking at the ImageIndex.
+// Images are resized and converted first when MaxImageWidth or ImageConvert is set.
+func (tm *ToMarkdown) writeImage(data []byte, localPath, visitPath, distDir string) (string, error) {
+	var err error
+	if tm.ImageConvert != "" || tm.MaxImageWidth > 0 {
+		data, localPath, visitPath, err = tm.processImage(data, localPath, visitPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	if dataURI, ok := tm.inlineImage(data, visitPath); ok {
+		return dataURI, nil
 	}
-	return visitPath, nil
+	store := tm.ImageStore
+	if store == nil {
+		store = FileImageStore{Dir: distDir, VisitPath: filepath.Dir(visitPath)}
+	}
+	return store.Put(filepath.Base(localPath), bytes.NewReader(data))
 }
 
 // injectBookmarkInfo sets image, title, and description from opengraph into the block's Extra map
 func (tm *ToMarkdown) injectBookmarkInfo(bookmark *notion.Bookmark, extra *map[string]interface{}) error {
-	og, err := opengraph.Fetch(bookmark.URL)
+	// the extra map is shared by all blocks, drop what a previous bookmark left
+	for _, key := range []string{"Image", "Title", "Description"} {
+		delete(*extra, key)
+	}
+	(*extra)["BookmarkFetched"] = false
+	if !tm.ExtendedSyntaxEnabled() && !tm.PlainBookmarkTitles {
+		// plain bookmarks link with their URL unless the title is asked for
+		return nil
+	}
+
+	info, err := tm.fetchBookmark(bookmark.URL)
 	if err != nil {
-		return err
+		// a slow or dead site must not abort the export
+		return nil
 	}
-	og.ToAbsURL()
-	for _, img := range og.Image {
-		if img != nil && img.URL != "" {
-			(*extra)["Image"] = img.URL
-			break
-		}
+	(*extra)["BookmarkFetched"] = true
+	if info.Image != "" {
+		(*extra)["Image"] = info.Image
 	}
-	(*extra)["Title"] = og.Title
-	(*extra)["Description"] = og.Description
+	(*extra)["Title"] = info.Title
+	(*extra)["Description"] = info.Description
 	return nil
 }
 
+// optionName normalizes the name of a select option: surrounding whitespace is
+// removed and inner runs of whitespace become a single space.
+func optionName(name string) string {
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// DBPropTypeStatus is the type of Notion status properties, which go-notion
+// doesn't know. Their option is expected in the Select field.
+const DBPropTypeStatus notion.DatabasePropertyType = "status"
+
+// PropertyValue returns the value of a page property like its Value method,
+// and the option of status properties.
+func PropertyValue(property notion.DatabasePageProperty) interface{} {
+	if property.Type == DBPropTypeStatus {
+		return property.Select
+	}
+	return property.Value()
+}
+
 // injectFrontMatter converts a Notion property into front matter data
 func (tm *ToMarkdown) injectFrontMatter(key string, property notion.DatabasePageProperty) {
 	var fmv interface{}
-	switch prop := property.Value().(type) {
+	switch prop := PropertyValue(property).(type) {
 	case *notion.SelectOptions:
 		if prop != nil {
-			fmv = prop.Name
+			fmv = optionName(prop.Name)
 		}
 	case []notion.SelectOptions:
+		// keep the order of the options as set in Notion
 		opts := make([]string, 0, len(prop))
 		for _, options := range prop {
-			opts = append(opts, options.Name)
+			if name := optionName(options.Name); name != "" {
+				opts = append(opts, name)
+			}
 		}
 		fmv = opts
 	case []notion.RichText:
 		fmv = ConvertRichText(prop)
 	case *time.Time:
 		if prop != nil {
-			fmv = prop.Format("2006-01-02T15:04:05+07:00")
+			fmv = prop.Format(DateFormat)
 		}
 	case *notion.Date:
-		if prop != nil {
+		if prop != nil && tm.DateRanges && prop.End != nil && !prop.Start.IsZero() && !prop.End.IsZero() {
+			tm.FrontMatter["start_"+key] = prop.Start.Format(DateFormat)
+			tm.FrontMatter["end_"+key] = prop.End.Format(DateFormat)
+		} else if prop != nil {
 			if !prop.Start.IsZero() {
-				fmv = prop.Start.Format("2006-01-02T15:04:05+07:00")
+				fmv = prop.Start.Format(DateFormat)
 			} else if !prop.End.IsZero() {
-				fmv = prop.End.Format("2006-01-02T15:04:05+07:00")
+				fmv = prop.End.Format(DateFormat)
 			}
 		}
 	case *notion.User:
 		if prop != nil {
 			fmv = prop.Name
 		}
+	case []notion.User:
+		// people properties, e.g. the authors of a post; users the integration
+		// can't see come without a name
+		names := make([]string, 0, len(prop))
+		for _, user := range prop {
+			if user.Name != "" {
+				names = append(names, user.Name)
+			}
+		}
+		fmv = names
 	case *string:
 		if prop != nil {
 			fmv = *prop
@@ -395,11 +1091,31 @@ func (tm *ToMarkdown) injectFrontMatter(key string, property notion.DatabasePage
 	}
 }
 
-// injectFrontMatterCover downloads the page cover image and sets the front matter "cover" field
+// injectPageEditors sets the CreatedByField and LastEditedByField of a page
+func (tm *ToMarkdown) injectPageEditors(pageID string) {
+	if (tm.CreatedByField == "" && tm.LastEditedByField == "") || tm.PageEditors == nil || pageID == "" {
+		return
+	}
+	createdBy, lastEditedBy := tm.PageEditors(pageID)
+	if tm.CreatedByField != "" && createdBy != "" {
+		tm.FrontMatter[tm.CreatedByField] = createdBy
+	}
+	if tm.LastEditedByField != "" && lastEditedBy != "" {
+		tm.FrontMatter[tm.LastEditedByField] = lastEditedBy
+	}
+}
+
+// injectFrontMatterCover downloads the page cover image and sets the front matter "cover" field.
+// Unsplash covers additionally get a "cover_credit" field.
 func (tm *ToMarkdown) injectFrontMatterCover(cover *notion.Cover) {
 	if cover == nil {
 		return
 	}
+	if cover.Type == notion.FileTypeExternal && cover.External != nil {
+		if credit, ok := unsplashCredit(cover.External.URL); ok {
+			tm.FrontMatter["cover_credit"] = credit
+		}
+	}
 	image := &notion.FileBlock{
 		Type:     cover.Type,
 		File:     cover.File,
@@ -415,6 +1131,185 @@ func (tm *ToMarkdown) injectFrontMatterCover(cover *notion.Cover) {
 	}
 }
 
+// templateOverride returns the path of tplName in tm.TemplateDir or, for the
+// CustomSyntaxTarget, in tm.ShortcodeTemplateDir, or "" when there is no such file.
+func (tm *ToMarkdown) templateOverride(tplName string) string {
+	dirs := []string{tm.TemplateDir}
+	if tm.ExtendedSyntaxEnabled() && tm.extra["ExtendedSyntaxTarget"] == CustomSyntaxTarget {
+		dirs = append(dirs, tm.ShortcodeTemplateDir)
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, tplName)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// unsplashCredit builds the attribution for an Unsplash cover URL. Notion only
+// exposes the image URL, so the photo is linked instead of the photographer
+// unless the URL points at an Unsplash profile or photo page.
+func unsplashCredit(rawURL string) (map[string]interface{}, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != "unsplash.com" && !strings.HasSuffix(host, ".unsplash.com") {
+		return nil, false
+	}
+
+	credit := map[string]interface{}{"source": "Unsplash"}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if strings.HasPrefix(segments[0], "@") && len(segments[0]) > 1 {
+		credit["name"] = strings.TrimPrefix(segments[0], "@")
+		credit["link"] = "https://unsplash.com/" + segments[0]
+	} else if len(segments) == 2 && segments[0] == "photos" {
+		credit["link"] = "https://unsplash.com/photos/" + segments[1]
+	} else {
+		credit["link"] = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+	}
+	return credit, true
+}
+
+// convertRichText renders rich text like ConvertRichText, but writes links
+// according to tm.LinkStyle and escapes plain text when tm.EscapeMarkdown is set.
+func (tm *ToMarkdown) convertRichText(t []notion.RichText) string {
+	var buf bytes.Buffer
+	for _, word := range t {
+		word, exported := tm.resolvePageReference(word)
+		if exported && tm.wikilinks() {
+			buf.WriteString(wikilink(word.Text.Content, word.Text.Link.URL))
+			continue
+		}
+		if tm.EscapeMarkdown && word.Type == notion.RichTextTypeText && word.Text != nil &&
+			(word.Annotations == nil || !word.Annotations.Code) {
+			text := *word.Text
+			text.Content = markdownEscaper.Replace(text.Content)
+			word.Text = &text
+		}
+		buf.WriteString(convertRich(word, tm.formatLink))
+	}
+	return buf.String()
+}
+
+// resolvePageReference turns a page or database mention into a text link and
+// points links to Notion pages that are part of the export at the exported
+// file, see PageLinkResolver. Other references are returned unchanged.
+// exported reports whether the returned link points at an exported file.
+func (tm *ToMarkdown) resolvePageReference(word notion.RichText) (_ notion.RichText, exported bool) {
+	switch {
+	case word.Type == notion.RichTextTypeMention && word.Mention != nil:
+		var id string
+		switch {
+		case word.Mention.Type == notion.MentionTypePage && word.Mention.Page != nil:
+			id = word.Mention.Page.ID
+		case word.Mention.Type == notion.MentionTypeDatabase && word.Mention.Database != nil:
+			id = word.Mention.Database.ID
+		default:
+			return word, false
+		}
+		link := tm.pageLink(id)
+		exported = link != ""
+		if link == "" && word.HRef != nil {
+			link = *word.HRef
+		}
+		if link == "" {
+			link = "https://www.notion.so/" + strings.ReplaceAll(id, "-", "")
+		}
+		word.Type = notion.RichTextTypeText
+		word.Text = &notion.Text{Content: word.PlainText, Link: &notion.Link{URL: link}}
+	case word.Type == notion.RichTextTypeText && word.Text != nil && word.Text.Link != nil:
+		if id, ok := notionPageID(word.Text.Link.URL); ok {
+			if link := tm.pageLink(id); link != "" {
+				text := *word.Text
+				text.Link = &notion.Link{URL: link}
+				word.Text = &text
+				exported = true
+			}
+		}
+	}
+	return word, exported
+}
+
+// notionPageID returns the dashed page ID a link to a Notion page points at,
+// e.g. /0123...cdef or https://www.notion.so/Title-0123...cdef.
+func notionPageID(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Host != "" && u.Host != "notion.so" && !strings.HasSuffix(u.Host, ".notion.so")) {
+		return "", false
+	}
+	path := strings.TrimRight(u.Path, "/")
+	if len(path) < 32 {
+		return "", false
+	}
+	id := path[len(path)-32:]
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	if rest := path[:len(path)-32]; !strings.HasSuffix(rest, "/") && !strings.HasSuffix(rest, "-") {
+		return "", false
+	}
+	return dashedID(id), true
+}
+
+// dashedID returns a Notion ID in the canonical dashed UUID form. IDs that are
+// no UUID are returned unchanged.
+func dashedID(id string) string {
+	compact := strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	if _, err := hex.DecodeString(compact); err != nil || len(compact) != 32 {
+		return id
+	}
+	return compact[0:8] + "-" + compact[8:12] + "-" + compact[12:16] + "-" + compact[16:20] + "-" + compact[20:]
+}
+
+// markdownEscaper backslash-escapes characters that would otherwise be read as
+// emphasis, headings, links, code spans or table separators.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"`", "\\`",
+	"*", `\*`,
+	"_", `\_`,
+	"#", `\#`,
+	"|", `\|`,
+	"[", `\[`,
+	"]", `\]`,
+	"~", `\~`,
+)
+
+// formatLink returns an inline link, or a numbered reference link when the
+// reference style is enabled. The same URL always reuses its number.
+func (tm *ToMarkdown) formatLink(text, url string) string {
+	if tm.LinkStyle != LinkStyleReference {
+		return inlineLink(text, url)
+	}
+	idx, ok := tm.linkRefIdx[url]
+	if !ok {
+		tm.linkRefs = append(tm.linkRefs, url)
+		idx = len(tm.linkRefs)
+		tm.linkRefIdx[url] = idx
+	}
+	return fmt.Sprintf("[%s][%d]", text, idx)
+}
+
+// genLinkReferences appends the collected reference definitions to the content
+func (tm *ToMarkdown) genLinkReferences() {
+	if len(tm.linkRefs) == 0 {
+		return
+	}
+	// keep the definitions separated from the content by a blank line
+	for !bytes.HasSuffix(tm.ContentBuffer.Bytes(), []byte("\n\n")) {
+		tm.ContentBuffer.WriteString("\n")
+	}
+	for i, url := range tm.linkRefs {
+		fmt.Fprintf(tm.ContentBuffer, "[%d]: %s\n", i+1, url)
+	}
+}
+
 // ConvertRichText joins multiple RichText objects into a single string
 func ConvertRichText(t []notion.RichText) string {
 	var buf bytes.Buffer
@@ -427,40 +1322,58 @@ func ConvertRichText(t []notion.RichText) string {
 
 // ConvertRich returns a single RichText as Markdown
 func ConvertRich(t notion.RichText) string {
+	return convertRich(t, inlineLink)
+}
+
+func inlineLink(text, url string) string {
+	return fmt.Sprintf("[%s](%s)", text, url)
+}
+
+func convertRich(t notion.RichText, link func(text, url string) string) string {
 	switch t.Type {
 	case notion.RichTextTypeText:
 		if t.Text.Link != nil {
-			return fmt.Sprintf(emphFormat(t.Annotations),
-				fmt.Sprintf("[%s](%s)", t.Text.Content, t.Text.Link.URL))
+			// the annotations go inside the link text, a code span around a
+			// link would show the link syntax as code
+			return link(fmt.Sprintf(emphFormat(t.Annotations), t.Text.Content), t.Text.Link.URL)
 		}
 		return fmt.Sprintf(emphFormat(t.Annotations), t.Text.Content)
 	case notion.RichTextTypeEquation:
-		// Not currently handled, skip or add your own format
+		if t.Equation != nil {
+			return inlineEquation(t.Equation.Expression)
+		}
 	case notion.RichTextTypeMention:
 		// Possibly format mention
 	}
 	return ""
 }
 
-// emphFormat generates markdown emphasis from annotations
+// emphFormat generates markdown emphasis from annotations. The markers nest
+// from the inside out as code, bold/italic, underline and strikethrough, so
+// every combination keeps all of its annotations, e.g. ~~<u>**x**</u>~~.
+// Markdown has no underline, it is written as <u>; __ would be bold. Code is
+// struck through with <del>, which renderers without ~~ support still show.
 func emphFormat(a *notion.Annotations) string {
 	s := "%s"
 	if a == nil {
 		return s
 	}
 	if a.Code {
-		return "`%s`"
+		s = "`%s`"
 	}
 	switch {
 	case a.Bold && a.Italic:
-		s = "***%s***"
+		s = "***" + s + "***"
 	case a.Bold:
-		s = "**%s**"
+		s = "**" + s + "**"
 	case a.Italic:
-		s = "*%s*"
+		s = "*" + s + "*"
 	}
 	if a.Underline {
-		s = "__" + s + "__"
+		s = "<u>" + s + "</u>"
+	}
+	if a.Strikethrough && a.Code {
+		s = "<del>" + s + "</del>"
 	} else if a.Strikethrough {
 		s = "~~" + s + "~~"
 	}
@@ -495,6 +1408,8 @@ func getChildrenBlocks(block MdBlock) []notion.Block {
 		return block.Table.Children
 	case notion.BlockTypeSyncedBlock:
 		return block.SyncedBlock.Children
+	case notion.BlockTypeHeading1, notion.BlockTypeHeading2, notion.BlockTypeHeading3:
+		return block.children
 	case notion.BlockTypeTemplate:
 		return block.Template.Children
 	default:
@@ -4,14 +4,22 @@ import (
 	"bytes"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dstotijn/go-notion"
 	"github.com/stretchr/testify/assert"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
 )
 
 //go:embed testdata
@@ -197,6 +205,73 @@ func testBlockConversionWithDepth(t *testing.T, depth int) {
 	}
 }
 
+// loadBlocks reads the blocks of a testdata JSON file
+func loadBlocks(t *testing.T, jsonPath string) []notion.Block {
+	t.Helper()
+	blockBytes, err := testdatas.ReadFile(jsonPath)
+	assert.NoError(t, err)
+	blocks := make([]notion.Block, 0)
+	assert.NoError(t, json.Unmarshal(blockBytes, &blocks))
+	return blocks
+}
+
+// assertGolden renders the blocks in jsonPath through GenerateTo and compares
+// the result with the golden file at goldenPath.
+func assertGolden(t *testing.T, tom *ToMarkdown, jsonPath, goldenPath string) {
+	t.Helper()
+	blocks := loadBlocks(t, jsonPath)
+	expected, err := testdatas.ReadFile(goldenPath)
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, string(expected), out.String())
+}
+
+// TestLinkStyle compares inline and reference links on the same paragraph
+func TestLinkStyle(t *testing.T) {
+	goldens := map[string]string{
+		LinkStyleInline:    "testdata/paragraph_links.inline.md",
+		LinkStyleReference: "testdata/paragraph_links.reference.md",
+	}
+	for style, golden := range goldens {
+		t.Run(style, func(t *testing.T) {
+			tom := New()
+			tom.LinkStyle = style
+			assertGolden(t, tom, "testdata/paragraph_links.json", golden)
+		})
+	}
+}
+
+func TestEscapeMarkdown(t *testing.T) {
+	text := func(content string, annotations *notion.Annotations, link *notion.Link) notion.RichText {
+		return notion.RichText{
+			Type:        notion.RichTextTypeText,
+			Annotations: annotations,
+			Text:        &notion.Text{Content: content, Link: link},
+		}
+	}
+	cases := []struct {
+		name     string
+		richText []notion.RichText
+		expected string
+	}{
+		{"asterisk", []notion.RichText{text("a * b", nil, nil)}, `a \* b`},
+		{"underscore", []notion.RichText{text("an underscore_word", nil, nil)}, `an underscore\_word`},
+		{"code span", []notion.RichText{text("a_b * c", &notion.Annotations{Code: true}, nil)}, "`a_b * c`"},
+		{"link", []notion.RichText{text("snake_case", nil, &notion.Link{URL: "https://example.com/a_b"})}, `[snake\_case](https://example.com/a_b)`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, New().convertRichText(c.richText))
+		})
+	}
+
+	tom := New()
+	tom.EscapeMarkdown = false
+	assert.Equal(t, "a * b", tom.convertRichText([]notion.RichText{text("a * b", nil, nil)}))
+}
+
 func TestOne(t *testing.T) {
 	testTarget(t, "vuepress")
 }
@@ -209,3 +284,1058 @@ func TestAllTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestSyncedBlockReference(t *testing.T) {
+	blocks := []notion.Block{{
+		Type: notion.BlockTypeSyncedBlock,
+		SyncedBlock: &notion.SyncedBlock{
+			SyncedFrom: &notion.SyncedFrom{Type: notion.SyncedFromTypeBlockID, BlockID: "original-block"},
+		},
+	}}
+
+	var fetched []string
+	tom := New()
+	tom.FetchBlockChildren = func(blockID string) ([]notion.Block, error) {
+		fetched = append(fetched, blockID)
+		return []notion.Block{{
+			Type: notion.BlockTypeParagraph,
+			Paragraph: &notion.RichTextBlock{
+				Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "synced content"}}},
+			},
+		}}, nil
+	}
+
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, []string{"original-block"}, fetched)
+	assert.Equal(t, "synced content\n", out.String())
+}
+
+func TestChildPageLinks(t *testing.T) {
+	tom := New()
+	tom.PageLinkResolver = func(pageID string) (string, bool) {
+		links := map[string]string{
+			"2b3c4d5e-0000-4000-8000-000000000001": "/posts/getting-started/",
+			"2b3c4d5e-0000-4000-8000-000000000003": "/posts/reading-list/",
+		}
+		link, ok := links[pageID]
+		return link, ok
+	}
+	assertGolden(t, tom, "testdata/child_page.json", "testdata/child_page.resolved.md")
+}
+
+func TestToggleHeadingChildren(t *testing.T) {
+	fetchChildren := func(blockID string) ([]notion.Block, error) {
+		assert.Equal(t, "4d5e6f70-0000-4000-8000-000000000001", blockID)
+		return []notion.Block{
+			{
+				Type:      notion.BlockTypeParagraph,
+				Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "Hidden answer"}}}},
+			},
+			{
+				Type:      notion.BlockTypeParagraph,
+				Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "Second answer"}}}},
+			},
+		}, nil
+	}
+
+	tom := New()
+	tom.FetchBlockChildren = fetchChildren
+	assertGolden(t, tom, "testdata/heading_toggle.json", "testdata/heading_toggle.fetched.md")
+
+	tom = New()
+	tom.FetchBlockChildren = fetchChildren
+	tom.ToggleHeadingDetails = true
+	assertGolden(t, tom, "testdata/heading_toggle.json", "testdata/heading_toggle.details.md")
+}
+
+func TestLinkToPage(t *testing.T) {
+	tom := New()
+	tom.PageLinkResolver = func(pageID string) (string, bool) {
+		if pageID == "2b3c4d5e-0000-4000-8000-000000000001" {
+			return "/posts/getting-started/", true
+		}
+		return "", false
+	}
+	tom.PageTitleResolver = func(pageID string) (string, bool) {
+		if pageID == "2b3c4d5e-0000-4000-8000-000000000001" {
+			return "Getting Started", true
+		}
+		return "", false
+	}
+	assertGolden(t, tom, "testdata/link_to_page.json", "testdata/link_to_page.resolved.md")
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestImageClient(t *testing.T) {
+	var requested []string
+	tom := New()
+	tom.ImgSavePath = t.TempDir()
+	tom.ImgVisitPath = "/images"
+	tom.ImageClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requested = append(requested, req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("image bytes")),
+			Header:     make(http.Header),
+		}, nil
+	})}
+
+	blocks := []notion.Block{{
+		Type: notion.BlockTypeImage,
+		Image: &notion.FileBlock{
+			Type:     notion.FileTypeExternal,
+			External: &notion.FileExternal{URL: "https://images.example.com/cat.png"},
+		},
+	}}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, []string{"https://images.example.com/cat.png"}, requested)
+
+	files, err := os.ReadDir(tom.ImgSavePath)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestCoverCredit(t *testing.T) {
+	tom := New()
+	tom.ImgSavePath = t.TempDir()
+	tom.ImgVisitPath = "/images"
+	tom.ImageClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("image bytes")),
+			Header:     make(http.Header),
+		}, nil
+	})}
+
+	tom.injectFrontMatterCover(&notion.Cover{
+		Type:     notion.FileTypeExternal,
+		External: &notion.FileExternal{URL: "https://images.unsplash.com/photo-1518791841217-8f162f1e1131?ixlib=rb-1.2.1&q=85&fm=jpg"},
+	})
+	assert.Equal(t, map[string]interface{}{
+		"source": "Unsplash",
+		"link":   "https://images.unsplash.com/photo-1518791841217-8f162f1e1131",
+	}, tom.FrontMatter["cover_credit"])
+	assert.True(t, strings.HasPrefix(tom.FrontMatter["cover"].(string), "/images/"))
+
+	credit, ok := unsplashCredit("https://unsplash.com/@jane_doe")
+	assert.True(t, ok)
+	assert.Equal(t, "jane_doe", credit["name"])
+	assert.Equal(t, "https://unsplash.com/@jane_doe", credit["link"])
+
+	_, ok = unsplashCredit("https://images.example.com/cat.png")
+	assert.False(t, ok)
+}
+
+func TestTemplateDir(t *testing.T) {
+	dir := t.TempDir()
+	override := "P: {{ rich2md .Paragraph.Text }}\n\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "paragraph.gohtml"), []byte(override), 0644))
+
+	tom := New()
+	tom.TemplateDir = dir
+	blocks := []notion.Block{
+		{
+			Type:      notion.BlockTypeParagraph,
+			Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "text"}}}},
+		},
+		{
+			Type:  notion.BlockTypeQuote,
+			Quote: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "quoted"}}}},
+		},
+	}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	// the paragraph comes from the override, the quote from the embedded template
+	assert.True(t, strings.HasPrefix(out.String(), "P: text\n\n"), out.String())
+	assert.Contains(t, out.String(), "> quoted")
+}
+
+func TestCustomShortcodeTemplates(t *testing.T) {
+	dir := t.TempDir()
+	callout := `{{"{{< admonition \""}}{{calloutEmoji .Callout}}{{"\" >}}"}}
+{{rich2md .Callout.Text}}
+{{"{{< /admonition >}}"}}
+`
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "callout.gohtml"), []byte(callout), 0644))
+
+	tom := New()
+	tom.ShortcodeTemplateDir = dir
+	tom.EnableExtendedSyntax(CustomSyntaxTarget)
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/callout.json")[:1], &out))
+	assert.Equal(t, "{{< admonition \"⚠️\" >}}\nBack up your data\nbefore upgrading.\n{{< /admonition >}}\n", out.String())
+
+	// the templates are only used by the custom target
+	tom = New()
+	tom.ShortcodeTemplateDir = dir
+	tom.EnableExtendedSyntax("hugo")
+	out.Reset()
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/callout.json")[:1], &out))
+	assert.NotContains(t, out.String(), "admonition")
+}
+
+func TestContentTemplateFuncs(t *testing.T) {
+	tplPath := filepath.Join(t.TempDir(), "content.tpl")
+	content := `{{ "Hello, World!" | slugify }} {{ .ContentBuffer.String | trim | upper }}`
+	assert.NoError(t, os.WriteFile(tplPath, []byte(content), 0644))
+
+	tom := New()
+	tom.ContentTemplate = tplPath
+	blocks := []notion.Block{{
+		Type:      notion.BlockTypeParagraph,
+		Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "body"}}}},
+	}}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, "hello-world BODY", out.String())
+}
+
+func TestContentTemplateFrontMatter(t *testing.T) {
+	blocks := []notion.Block{{
+		Type:      notion.BlockTypeParagraph,
+		Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "body"}}}},
+	}}
+	templates := map[string]string{
+		// the template places the front matter itself, below a comment
+		`<!-- {{ .FrontMatter.title }} -->
+{{ frontMatter }}{{ .ContentBuffer.String | trim }}`: "<!-- Hello -->\n---\ntitle: Hello\n---\n\nbody",
+		// without {{ frontMatter }} it is written above the template output
+		`{{ .ContentBuffer.String | trim }}`: "---\ntitle: Hello\n---\n\nbody",
+	}
+	for content, expected := range templates {
+		tplPath := filepath.Join(t.TempDir(), "content.tpl")
+		assert.NoError(t, os.WriteFile(tplPath, []byte(content), 0644))
+
+		tom := New()
+		tom.ContentTemplate = tplPath
+		tom.FrontMatter["title"] = "Hello"
+		var out bytes.Buffer
+		assert.NoError(t, tom.GenerateTo(blocks, &out))
+		assert.Equal(t, expected, out.String())
+	}
+}
+
+func TestWrapperTemplate(t *testing.T) {
+	blocks := []notion.Block{{
+		Type:      notion.BlockTypeParagraph,
+		Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "body"}}}},
+	}}
+	dir := t.TempDir()
+	wrapper := filepath.Join(dir, "wrapper.tpl")
+	assert.NoError(t, os.WriteFile(wrapper, []byte(`<link rel="canonical" href="https://example.com/{{ .FrontMatter.title | slugify }}/">
+
+{{ .Content }}
+License: CC BY 4.0
+`), 0644))
+
+	tom := New()
+	tom.WrapperTemplate = wrapper
+	tom.FrontMatter["title"] = "Hello World"
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	// the front matter stays at the top of the file
+	assert.Equal(t, "---\ntitle: Hello World\n---\n\n<link rel=\"canonical\" href=\"https://example.com/hello-world/\">\n\nbody\n\nLicense: CC BY 4.0\n", out.String())
+
+	// the wrapper surrounds the output of the content template
+	tom = New()
+	tom.WrapperTemplate = wrapper
+	tom.ContentTemplate = filepath.Join(dir, "content.tpl")
+	assert.NoError(t, os.WriteFile(tom.ContentTemplate, []byte("# {{ .FrontMatter.title }}\n"), 0644))
+	tom.FrontMatter["title"] = "Hello World"
+	out.Reset()
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, "---\ntitle: Hello World\n---\n\n<link rel=\"canonical\" href=\"https://example.com/hello-world/\">\n\n# Hello World\n\nLicense: CC BY 4.0\n", out.String())
+}
+
+func TestLastmodFrontMatter(t *testing.T) {
+	page := notion.Page{
+		LastEditedTime: time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC),
+		Properties:     notion.DatabasePageProperties{},
+	}
+
+	tom := New()
+	tom.WithFrontMatter(page)
+	assert.Equal(t, "2024-03-05T14:30:00Z", tom.FrontMatter["lastmod"])
+
+	// the offset is the one of the time, not a fixed one
+	page.LastEditedTime = time.Date(2024, 3, 5, 14, 30, 0, 0, time.FixedZone("", -5*60*60))
+	tom = New()
+	tom.WithFrontMatter(page)
+	assert.Equal(t, "2024-03-05T14:30:00-05:00", tom.FrontMatter["lastmod"])
+
+	tom = New()
+	tom.LastmodField = "updated"
+	tom.WithFrontMatter(page)
+	assert.Equal(t, page.LastEditedTime.Format(DateFormat), tom.FrontMatter["updated"])
+	assert.NotContains(t, tom.FrontMatter, "lastmod")
+}
+
+func TestPageIDFrontMatter(t *testing.T) {
+	page := notion.Page{ID: "2B3C4D5E000040008000000000000001"}
+
+	tom := New()
+	tom.WithFrontMatter(page)
+	assert.NotContains(t, tom.FrontMatter, "notion_id")
+
+	tom = New()
+	tom.PageIDField = "notion_id"
+	tom.WithFrontMatter(page)
+	assert.Equal(t, "2b3c4d5e-0000-4000-8000-000000000001", tom.FrontMatter["notion_id"])
+}
+
+func TestPageEditorsFrontMatter(t *testing.T) {
+	tom := New()
+	tom.CreatedByField = "author"
+	tom.LastEditedByField = "editor"
+	tom.PageEditors = func(pageID string) (string, string) {
+		assert.Equal(t, "page-1", pageID)
+		return "Ada Lovelace", "Charles Babbage"
+	}
+	tom.WithFrontMatter(notion.Page{ID: "page-1"})
+	assert.Equal(t, "Ada Lovelace", tom.FrontMatter["author"])
+	assert.Equal(t, "Charles Babbage", tom.FrontMatter["editor"])
+}
+
+func TestFrontMatterDefaults(t *testing.T) {
+	author := "Jane"
+	page := notion.Page{
+		Properties: notion.DatabasePageProperties{
+			"author": {Type: notion.DBPropTypeRichText, RichText: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: author}}}},
+			"status": {Type: notion.DBPropTypeSelect, Select: &notion.SelectOptions{Name: "Published"}},
+		},
+	}
+
+	tom := New()
+	tom.FrontMatterDefaults = map[string]interface{}{
+		"layout": "post",
+		"author": "me",
+	}
+	tom.WithFrontMatter(page)
+	assert.Equal(t, "post", tom.FrontMatter["layout"])
+	assert.Equal(t, author, tom.FrontMatter["author"])
+	assert.Equal(t, "Published", tom.FrontMatter["status"])
+}
+
+func TestFrontMatterMapping(t *testing.T) {
+	text := func(content string) notion.DatabasePageProperty {
+		return notion.DatabasePageProperty{Type: notion.DBPropTypeRichText, RichText: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: content}}}}
+	}
+	published := time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)
+	page := notion.Page{
+		Properties: notion.DatabasePageProperties{
+			"PublishDate": {Type: notion.DBPropTypeDate, Date: &notion.Date{Start: notion.NewDateTime(published, false)}},
+			"Date":        text("created in Notion"),
+			"Summary":     text("About this post"),
+			"Internal":    text("notes"),
+			"author":      text("Jane"),
+		},
+	}
+
+	tom := New()
+	tom.FrontMatterMapping = map[string]string{
+		"publishdate": "date",
+		"Summary":     "description",
+		"Internal":    "",
+	}
+	tom.WithFrontMatter(page)
+	assert.Equal(t, map[string]interface{}{
+		"date":        published.Format(DateFormat),
+		"description": "About this post",
+		"author":      "Jane",
+	}, tom.FrontMatter)
+}
+
+func TestDateRanges(t *testing.T) {
+	start := notion.NewDateTime(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), false)
+	end := notion.NewDateTime(time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC), false)
+	page := notion.Page{
+		Properties: notion.DatabasePageProperties{
+			"date":      {Type: notion.DBPropTypeDate, Date: &notion.Date{Start: start, End: &end}},
+			"published": {Type: notion.DBPropTypeDate, Date: &notion.Date{Start: start}},
+		},
+	}
+
+	// by default only the start is kept
+	tom := New()
+	tom.WithFrontMatter(page)
+	assert.Equal(t, start.Format(DateFormat), tom.FrontMatter["date"])
+
+	tom = New()
+	tom.DateRanges = true
+	tom.WithFrontMatter(page)
+	assert.Equal(t, map[string]interface{}{
+		"start_date": start.Format(DateFormat),
+		"end_date":   end.Format(DateFormat),
+		"published":  start.Format(DateFormat),
+	}, tom.FrontMatter)
+}
+
+func TestPeopleProperty(t *testing.T) {
+	page := notion.Page{
+		Properties: notion.DatabasePageProperties{
+			"authors": {Type: notion.DBPropTypePeople, People: []notion.User{
+				{ID: "1", Type: notion.UserTypePerson, Name: "Ada Lovelace"},
+				{ID: "2", Type: notion.UserTypePerson, Name: "Charles Babbage"},
+			}},
+			"reviewers": {Type: notion.DBPropTypePeople, People: []notion.User{}},
+		},
+	}
+	tom := New()
+	tom.WithFrontMatter(page)
+	assert.Equal(t, []string{"Ada Lovelace", "Charles Babbage"}, tom.FrontMatter["authors"])
+	assert.Equal(t, []string{}, tom.FrontMatter["reviewers"])
+}
+
+func TestMultiSelectOrder(t *testing.T) {
+	page := notion.Page{
+		Properties: notion.DatabasePageProperties{
+			"tags": {Type: notion.DBPropTypeMultiSelect, MultiSelect: []notion.SelectOptions{
+				{Name: "zeta"}, {Name: " Go  Modules "}, {Name: "alpha"}, {Name: "  "}, {Name: "mid"},
+			}},
+			"category": {Type: notion.DBPropTypeSelect, Select: &notion.SelectOptions{Name: " Dev\tNotes"}},
+		},
+	}
+	tom := New()
+	tom.WithFrontMatter(page)
+	assert.Equal(t, []string{"zeta", "Go Modules", "alpha", "mid"}, tom.FrontMatter["tags"])
+	assert.Equal(t, "Dev Notes", tom.FrontMatter["category"])
+}
+
+func TestDisableFrontMatter(t *testing.T) {
+	tom := New()
+	tom.DisableFrontMatter = true
+	tom.FrontMatter["title"] = "Hello"
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/headings.json")[:2], &out))
+	assert.Equal(t, "# Getting Started\n\nIntro\n", out.String())
+	assert.NotContains(t, out.String(), "---")
+
+	// content templates can still use the fields
+	tom = New()
+	tom.DisableFrontMatter = true
+	tom.FrontMatter["title"] = "Hello"
+	tom.ContentTemplate = filepath.Join(t.TempDir(), "content.tpl")
+	assert.NoError(t, os.WriteFile(tom.ContentTemplate, []byte("{{ frontMatter }}# {{ .FrontMatter.title }}\n"), 0644))
+	out.Reset()
+	assert.NoError(t, tom.GenerateTo(nil, &out))
+	assert.Equal(t, "# Hello\n", out.String())
+}
+
+func TestFrontMatterFormat(t *testing.T) {
+	for _, format := range []string{FrontMatterYAML, FrontMatterTOML, FrontMatterJSON} {
+		tom := New()
+		tom.FrontMatterFormat = format
+		tom.FrontMatter["title"] = "Hello, World"
+		tom.FrontMatter["tags"] = []string{"go", "notion"}
+		tom.FrontMatter["weight"] = 3
+		var out bytes.Buffer
+		assert.NoError(t, tom.GenFrontMatter(&out))
+
+		expected, err := testdatas.ReadFile("testdata/front_matter." + format + ".md")
+		assert.NoError(t, err)
+		assert.Equal(t, string(expected), out.String(), format)
+	}
+}
+
+func TestBookmarkFetchFailure(t *testing.T) {
+	blocks := []notion.Block{{
+		Type:     notion.BlockTypeBookmark,
+		Bookmark: &notion.Bookmark{URL: "https://unreachable.invalid/post"},
+	}}
+	// bookmarks are only rendered with extended syntax
+	for _, target := range []string{"hugo", "vuepress"} {
+		tom := New()
+		tom.EnableExtendedSyntax(target)
+		tom.BookmarkClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, fmt.Errorf("dial tcp: lookup %s: no such host", req.URL.Host)
+		})}
+
+		var out bytes.Buffer
+		assert.NoError(t, tom.GenerateTo(blocks, &out))
+		assert.Equal(t, "<https://unreachable.invalid/post>\n", out.String(), target)
+	}
+}
+
+func TestBookmarkCache(t *testing.T) {
+	requests := 0
+	client := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("<html><head><title>Post</title></head></html>")),
+			Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		}, nil
+	})}
+	cache := NewBookmarkCache()
+	blocks := []notion.Block{{
+		Type:     notion.BlockTypeBookmark,
+		Bookmark: &notion.Bookmark{URL: "https://example.com/post"},
+	}}
+
+	// two pages sharing the same bookmark
+	for i := 0; i < 2; i++ {
+		tom := New()
+		tom.EnableExtendedSyntax("hugo")
+		tom.BookmarkClient = client
+		tom.BookmarkCache = cache
+		assert.NoError(t, tom.GenerateTo(blocks, io.Discard))
+	}
+	assert.Equal(t, 1, requests)
+
+	// the cache survives a save and load
+	path := filepath.Join(t.TempDir(), "bookmarks.json")
+	assert.NoError(t, cache.Save(path))
+	loaded, err := LoadBookmarkCache(path)
+	assert.NoError(t, err)
+	_, ok := loaded.get("https://example.com/post")
+	assert.True(t, ok)
+}
+
+func TestEmphFormat(t *testing.T) {
+	// every annotation combination, markers nest as code, bold/italic,
+	// underline and strikethrough from the inside out; underline is <u> and
+	// strikethrough is <del> on code
+	tests := []struct {
+		code, bold, italic, underline, strikethrough bool
+		want                                         string
+	}{
+		{false, false, false, false, false, "x"},
+		{false, false, false, false, true, "~~x~~"},
+		{false, false, false, true, false, "<u>x</u>"},
+		{false, false, false, true, true, "~~<u>x</u>~~"},
+		{false, false, true, false, false, "*x*"},
+		{false, false, true, false, true, "~~*x*~~"},
+		{false, false, true, true, false, "<u>*x*</u>"},
+		{false, false, true, true, true, "~~<u>*x*</u>~~"},
+		{false, true, false, false, false, "**x**"},
+		{false, true, false, false, true, "~~**x**~~"},
+		{false, true, false, true, false, "<u>**x**</u>"},
+		{false, true, false, true, true, "~~<u>**x**</u>~~"},
+		{false, true, true, false, false, "***x***"},
+		{false, true, true, false, true, "~~***x***~~"},
+		{false, true, true, true, false, "<u>***x***</u>"},
+		{false, true, true, true, true, "~~<u>***x***</u>~~"},
+		{true, false, false, false, false, "`x`"},
+		{true, false, false, false, true, "<del>`x`</del>"},
+		{true, false, false, true, false, "<u>`x`</u>"},
+		{true, false, false, true, true, "<del><u>`x`</u></del>"},
+		{true, false, true, false, false, "*`x`*"},
+		{true, false, true, false, true, "<del>*`x`*</del>"},
+		{true, false, true, true, false, "<u>*`x`*</u>"},
+		{true, false, true, true, true, "<del><u>*`x`*</u></del>"},
+		{true, true, false, false, false, "**`x`**"},
+		{true, true, false, false, true, "<del>**`x`**</del>"},
+		{true, true, false, true, false, "<u>**`x`**</u>"},
+		{true, true, false, true, true, "<del><u>**`x`**</u></del>"},
+		{true, true, true, false, false, "***`x`***"},
+		{true, true, true, false, true, "<del>***`x`***</del>"},
+		{true, true, true, true, false, "<u>***`x`***</u>"},
+		{true, true, true, true, true, "<del><u>***`x`***</u></del>"},
+	}
+	for _, tt := range tests {
+		a := &notion.Annotations{
+			Code:          tt.code,
+			Bold:          tt.bold,
+			Italic:        tt.italic,
+			Underline:     tt.underline,
+			Strikethrough: tt.strikethrough,
+		}
+		assert.Equal(t, tt.want, fmt.Sprintf(emphFormat(a), "x"), "%+v", *a)
+	}
+	assert.Equal(t, "x", fmt.Sprintf(emphFormat(nil), "x"))
+}
+
+func TestAnnotatedLink(t *testing.T) {
+	link := &notion.Link{URL: "https://pkg.go.dev/fmt#Sprintf"}
+	code := notion.RichText{
+		Type:        notion.RichTextTypeText,
+		Annotations: &notion.Annotations{Code: true},
+		Text:        &notion.Text{Content: "fmt.Sprintf", Link: link},
+	}
+	assert.Equal(t, "[`fmt.Sprintf`](https://pkg.go.dev/fmt#Sprintf)", ConvertRich(code))
+
+	bold := notion.RichText{
+		Type:        notion.RichTextTypeText,
+		Annotations: &notion.Annotations{Bold: true},
+		Text:        &notion.Text{Content: "the docs", Link: link},
+	}
+	assert.Equal(t, "[**the docs**](https://pkg.go.dev/fmt#Sprintf)", ConvertRich(bold))
+
+	// reference links keep the annotations in the link text as well
+	tom := New()
+	tom.LinkStyle = LinkStyleReference
+	assert.Equal(t, "[`fmt.Sprintf`][1]", tom.convertRichText([]notion.RichText{code}))
+}
+
+// TestContinuousNumbering renders a numbered list split by an empty paragraph
+func TestContinuousNumbering(t *testing.T) {
+	assertGolden(t, New(), "testdata/numbered_split.json", "testdata/numbered_split.restart.md")
+
+	tom := New()
+	tom.ContinuousNumbering = true
+	assertGolden(t, tom, "testdata/numbered_split.json", "testdata/numbered_split.continuous.md")
+}
+
+func TestSyncedBlockMarkers(t *testing.T) {
+	tom := New()
+	tom.SyncedBlockMarkers = true
+	assertGolden(t, tom, "testdata/synced_block.json", "testdata/synced_block.markers.md")
+}
+
+func TestFrontMatterBlock(t *testing.T) {
+	tom := New()
+	tom.FrontMatter["title"] = "From the properties"
+	tom.FrontMatter["author"] = "Jane"
+	assertGolden(t, tom, "testdata/front_matter_block.json", "testdata/front_matter_block.page.md")
+
+	// a yaml code block further down is regular content
+	tom = New()
+	blocks := []notion.Block{
+		{Type: notion.BlockTypeParagraph, Paragraph: &notion.RichTextBlock{Text: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "Intro"}}}}},
+	}
+	blocks = append(blocks, loadBlocks(t, "testdata/front_matter_block.json")...)
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Empty(t, tom.FrontMatter)
+	assert.Contains(t, out.String(), "layout: wide")
+}
+
+func TestPageReferenceLinks(t *testing.T) {
+	exported := "2b3c4d5e-0000-4000-8000-000000000001"
+	href := "https://www.notion.so/2b3c4d5e000040008000000000000001"
+	text := []notion.RichText{
+		{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "See "}},
+		{
+			Type:      notion.RichTextTypeMention,
+			PlainText: "Getting Started",
+			HRef:      &href,
+			Mention:   &notion.Mention{Type: notion.MentionTypePage, Page: &notion.ID{ID: exported}},
+		},
+		{Type: notion.RichTextTypeText, Text: &notion.Text{Content: ", the "}},
+		{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "guide", Link: &notion.Link{URL: "https://www.notion.so/Getting-Started-2b3c4d5e000040008000000000000001"}}},
+		{Type: notion.RichTextTypeText, Text: &notion.Text{Content: " or "}},
+		{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "another", Link: &notion.Link{URL: "/9f8e7d6c000040008000000000000009"}}},
+	}
+
+	tom := New()
+	tom.PageLinkResolver = func(pageID string) (string, bool) {
+		return "getting-started.md", pageID == exported
+	}
+	assert.Equal(t, "See [Getting Started](getting-started.md), the [guide](getting-started.md) or "+
+		"[another](/9f8e7d6c000040008000000000000009)", tom.convertRichText(text))
+
+	// without a resolver mentions link to Notion
+	assert.Equal(t, "[Getting Started]("+href+")", New().convertRichText(text[1:2]))
+}
+
+func TestWikilinks(t *testing.T) {
+	tom := New()
+	tom.InternalLinkStyle = InternalLinkStyleWikilink
+	tom.PageLinkResolver = func(pageID string) (string, bool) {
+		return "getting-started.md", pageID == "2b3c4d5e-0000-4000-8000-000000000001"
+	}
+	assertGolden(t, tom, "testdata/page_mention.json", "testdata/page_mention.wikilink.md")
+
+	// downloaded images are embedded, images kept at their URL are linked
+	var blocks []notion.Block
+	assert.NoError(t, json.Unmarshal([]byte(`[
+  {"type": "image", "image": {"type": "external", "external": {"url": "https://img.example.com/diagram.png"}, "caption": [
+    {"type": "text", "text": {"content": "Request flow"}}
+  ]}},
+  {"type": "image", "image": {"type": "external", "external": {"url": "https://example.com/remote.png"}, "caption": [
+    {"type": "text", "text": {"content": "Remote"}}
+  ]}}
+]`), &blocks))
+	tom = New()
+	tom.InternalLinkStyle = InternalLinkStyleWikilink
+	tom.ImgSavePath = t.TempDir()
+	tom.ImgVisitPath = "/images/post"
+	tom.ImageClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "img.example.com" {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: http.NoBody, Request: req}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("\x89PNG")), Request: req}, nil
+	})}
+	expected, err := testdatas.ReadFile("testdata/image.wikilink.md")
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, string(expected), out.String())
+}
+
+func TestWikilink(t *testing.T) {
+	assert.Equal(t, "[[getting-started|Getting Started]]", wikilink("Getting Started", "../posts/getting-started.md"))
+	assert.Equal(t, "[[Getting Started]]", wikilink("Getting Started", "Getting%20Started.md"))
+	assert.Equal(t, "[[v1.2-release|Release 1.2]]", wikilink("Release 1.2", "/posts/v1.2-release/"))
+	assert.Equal(t, "[[faq|Q A]]", wikilink("Q|A", "faq.md"))
+}
+
+func TestNotionPageID(t *testing.T) {
+	for link, want := range map[string]string{
+		"/2b3c4d5e000040008000000000000001":                                  "2b3c4d5e-0000-4000-8000-000000000001",
+		"https://www.notion.so/Title-2b3c4d5e000040008000000000000001":       "2b3c4d5e-0000-4000-8000-000000000001",
+		"https://notion.so/workspace/2b3c4d5e000040008000000000000001?v=abc": "2b3c4d5e-0000-4000-8000-000000000001",
+		"https://example.com/2b3c4d5e000040008000000000000001":               "",
+		"https://www.notion.so/Title-abc":                                    "",
+	} {
+		id, ok := notionPageID(link)
+		assert.Equal(t, want, id, link)
+		assert.Equal(t, want != "", ok, link)
+	}
+}
+
+func TestPlainCallout(t *testing.T) {
+	tom := New()
+	tom.PlainFallback = true
+	assertGolden(t, tom, "testdata/callout.json", "testdata/callout.plain.md")
+
+	// without the fallback callouts are left out
+	var out bytes.Buffer
+	assert.NoError(t, New().GenerateTo(loadBlocks(t, "testdata/callout.json"), &out))
+	assert.Equal(t, "After the callouts\n", out.String())
+}
+
+// TestCalloutChildren checks that the children of a callout stay inside its shortcode
+func TestCalloutChildren(t *testing.T) {
+	tom := New()
+	tom.EnableExtendedSyntax("hugo")
+	assertGolden(t, tom, "testdata/callout.json", "testdata/callout.hugo.md")
+}
+
+// TestColorClass renders colored callouts and quotes with a CSS class. The
+// colors are not part of the blocks go-notion decodes.
+func TestColorClass(t *testing.T) {
+	colors := map[string]string{
+		"5e6f7081-0000-4000-8000-000000000001": "red_background",
+		"5e6f7081-0000-4000-8000-000000000002": "blue",
+		"5e6f7081-0000-4000-8000-000000000003": "default",
+		"5e6f7081-0000-4000-8000-000000000004": "yellow_background",
+	}
+	for _, target := range []string{"hugo", "hexo"} {
+		tom := New()
+		tom.EnableExtendedSyntax(target)
+		tom.BlockColor = func(blockID string) (string, bool) {
+			color, ok := colors[blockID]
+			return color, ok
+		}
+		assertGolden(t, tom, "testdata/colors.json", "testdata/colors."+target+".md")
+	}
+}
+
+func TestPlainBookmark(t *testing.T) {
+	noRequests := &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected bookmark request to %s", req.URL)
+		return nil, fmt.Errorf("no requests expected")
+	})}
+
+	tom := New()
+	tom.PlainFallback = true
+	tom.BookmarkClient = noRequests
+	assertGolden(t, tom, "testdata/bookmark.json", "testdata/bookmark.plain.md")
+
+	// with titles the fetched title is the link text, bookmarks without a title keep the URL
+	cache := NewBookmarkCache()
+	cache.set("https://go.dev/blog/", BookmarkInfo{Title: "The Go Blog"})
+	cache.set("https://example.com/untitled", BookmarkInfo{})
+	tom = New()
+	tom.PlainFallback = true
+	tom.PlainBookmarkTitles = true
+	tom.BookmarkClient = noRequests
+	tom.BookmarkCache = cache
+	assertGolden(t, tom, "testdata/bookmark.json", "testdata/bookmark.titles.md")
+}
+
+func TestCodeCaption(t *testing.T) {
+	tom := New()
+	tom.CodeCaption = CodeCaptionLine
+	assertGolden(t, tom, "testdata/code_caption.json", "testdata/code_caption.line.md")
+
+	tom = New()
+	tom.EnableExtendedSyntax("hugo")
+	tom.CodeCaption = CodeCaptionTitle
+	assertGolden(t, tom, "testdata/code_caption.json", "testdata/code_caption.hugo.md")
+
+	// captions are left out by default
+	var out bytes.Buffer
+	assert.NoError(t, New().GenerateTo(loadBlocks(t, "testdata/code_caption.json"), &out))
+	assert.NotContains(t, out.String(), "main.go")
+}
+
+func TestWideTableWrapper(t *testing.T) {
+	tom := New()
+	tom.WideTableColumns = 3
+	assertGolden(t, tom, "testdata/table_wide.json", "testdata/table_wide.wrapped.md")
+
+	// tables up to the threshold are not wrapped
+	tom = New()
+	tom.WideTableColumns = 4
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/table_wide.json"), &out))
+	assert.NotContains(t, out.String(), "table-wrapper")
+
+	tom = New()
+	tom.WideTableColumns = 3
+	tom.WideTableWrapper = "{{< scroll >}}\n%s{{< /scroll >}}"
+	out.Reset()
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/table_wide.json"), &out))
+	assert.True(t, strings.HasPrefix(out.String(), "{{< scroll >}}\n| Name |"), out.String())
+
+	// the table follows a wrapper without %s instead of being dropped
+	tom = New()
+	tom.WideTableColumns = 3
+	tom.WideTableWrapper = "<!-- wide -->"
+	out.Reset()
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/table_wide.json"), &out))
+	assert.True(t, strings.HasPrefix(out.String(), "<!-- wide -->\n| Name |"), out.String())
+}
+
+// TestIndentUnit renders toggles at depth 2 with four spaces and tabs, the
+// depth golden files being written with four spaces per level
+func TestIndentUnit(t *testing.T) {
+	golden, err := testdatas.ReadFile("testdata/toggle_depth2.md")
+	assert.NoError(t, err)
+
+	for _, unit := range []string{"    ", "\t"} {
+		tom := New()
+		tom.IndentUnit = unit
+		for _, block := range loadBlocks(t, "testdata/toggle.json") {
+			mdb := MdBlock{Block: block, Depth: 2, Extra: make(map[string]interface{})}
+			assert.NoError(t, tom.GenBlock(block.Type, mdb))
+		}
+		expected := strings.ReplaceAll(string(golden), "    ", unit)
+		assert.Equal(t, expected, strings.TrimPrefix(tom.ContentBuffer.String(), "\n"), "unit %q", unit)
+	}
+}
+
+// TestListNesting renders a 3-level mixed list with four spaces and tabs, and
+// checks with a CommonMark parser that each item and code block of the four
+// spaces version stays in its parent item. Goldmark doesn't close fences
+// indented with tabs, so the tabs version is only compared with the golden.
+func TestListNesting(t *testing.T) {
+	golden, err := testdatas.ReadFile("testdata/list_nested.normalized.md")
+	assert.NoError(t, err)
+
+	for _, unit := range []string{"    ", "\t"} {
+		tom := New()
+		tom.IndentUnit = unit
+		var out bytes.Buffer
+		assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/list_nested.json"), &out))
+		assert.Equal(t, strings.ReplaceAll(string(golden), "    ", unit), out.String(), "unit %q", unit)
+	}
+
+	doc := goldmark.New().Parser().Parse(text.NewReader(golden))
+	expected := "ul[li(Fruits ol[li(Apples ul[li(Fuji)] code(eat(apple))) li(Pears)]) li(Vegetables)]"
+	assert.Equal(t, expected, listOutline(doc, golden))
+}
+
+// listOutline describes the lists, list items, text and code blocks below n
+func listOutline(n ast.Node, source []byte) string {
+	var parts []string
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		switch c := c.(type) {
+		case *ast.List:
+			kind := "ul"
+			if c.IsOrdered() {
+				kind = "ol"
+			}
+			parts = append(parts, kind+"["+listOutline(c, source)+"]")
+		case *ast.ListItem:
+			parts = append(parts, "li("+listOutline(c, source)+")")
+		case *ast.Paragraph, *ast.TextBlock:
+			parts = append(parts, string(c.Text(source)))
+		case *ast.FencedCodeBlock:
+			var code []string
+			for i := 0; i < c.Lines().Len(); i++ {
+				line := c.Lines().At(i)
+				code = append(code, strings.TrimSpace(string(line.Value(source))))
+			}
+			parts = append(parts, "code("+strings.Join(code, " ")+")")
+		default:
+			parts = append(parts, c.Kind().String())
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// imageCaptionBlocks holds images with captions. They are built here instead of
+// in testdata so the converter tests walking testdata don't download them.
+const imageCaptionBlocks = `[
+  {"type": "image", "image": {"type": "external", "external": {"url": "https://example.com/gopher.png"}, "caption": [
+    {"type": "text", "text": {"content": "The "}},
+    {"type": "text", "text": {"content": "Go"}, "annotations": {"bold": true}},
+    {"type": "text", "text": {"content": " gopher | Drawn by \"Renee\" French"}}
+  ]}},
+  {"type": "image", "image": {"type": "external", "external": {"url": "https://example.com/plain.png"}, "caption": [
+    {"type": "text", "text": {"content": "Caption only"}}
+  ]}}
+]`
+
+func TestImageCaptionTitle(t *testing.T) {
+	var blocks []notion.Block
+	assert.NoError(t, json.Unmarshal([]byte(imageCaptionBlocks), &blocks))
+
+	for separator, golden := range map[string]string{
+		"":  "testdata/image_caption.caption.md",
+		"|": "testdata/image_caption.title.md",
+	} {
+		tom := New()
+		tom.KeepRemoteImages = true
+		tom.ImageTitleSeparator = separator
+		expected, err := testdatas.ReadFile(golden)
+		assert.NoError(t, err)
+
+		var out bytes.Buffer
+		assert.NoError(t, tom.GenerateTo(blocks, &out))
+		assert.Equal(t, string(expected), out.String(), golden)
+	}
+}
+
+func TestInlineEquation(t *testing.T) {
+	var blocks []notion.Block
+	assert.NoError(t, json.Unmarshal([]byte(`[
+  {"type": "image", "image": {"type": "external", "external": {"url": "https://example.com/emc2.png"}, "caption": [
+    {"type": "text", "text": {"content": "Mass-energy equivalence "}},
+    {"type": "equation", "equation": {"expression": "E = mc^2"}}
+  ]}}
+]`), &blocks))
+
+	tom := New()
+	tom.KeepRemoteImages = true
+	tom.EscapeMarkdown = true
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, "![Mass-energy equivalence $E = mc^2$](https://example.com/emc2.png)\n", out.String())
+
+	// captions, table cells and front matter values keep the equation as well
+	assert.Equal(t, "Mass-energy equivalence $E = mc^2$", ConvertRichText(blocks[0].Image.Caption))
+}
+
+func TestHeadings(t *testing.T) {
+	tom := New()
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/headings.json"), io.Discard))
+	assert.Equal(t, []Heading{
+		{Text: "Getting Started", Level: 1, Slug: "getting-started"},
+		{Text: "Install notion-md-gen", Level: 2, Slug: "install-notion-md-gen"},
+		{Text: "On macOS?", Level: 3, Slug: "on-macos"},
+		{Text: "Configuration", Level: 2, Slug: "configuration"},
+		{Text: "FAQ", Level: 1, Slug: "faq"},
+	}, tom.Headings())
+
+	// every page starts a new list
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/headings.json")[5:], io.Discard))
+	assert.Equal(t, []Heading{{Text: "FAQ", Level: 1, Slug: "faq"}}, tom.Headings())
+}
+
+func TestSpacing(t *testing.T) {
+	assertGolden(t, New(), "testdata/spacing.json", "testdata/spacing.normalized.md")
+}
+
+func TestPostProcess(t *testing.T) {
+	tom := New()
+	tom.FrontMatter["title"] = "Hello"
+	tom.PostProcess = func(content string) (string, error) {
+		return strings.ToUpper(content), nil
+	}
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(loadBlocks(t, "testdata/headings.json")[:2], &out))
+	// the front matter is left alone
+	assert.Equal(t, "---\ntitle: Hello\n---\n\n# GETTING STARTED\n\nINTRO\n", out.String())
+
+	tom = New()
+	tom.PostProcess = func(content string) (string, error) {
+		return "", fmt.Errorf("broken shortcode")
+	}
+	out.Reset()
+	err := tom.GenerateTo(loadBlocks(t, "testdata/headings.json"), &out)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "broken shortcode")
+	}
+	assert.Empty(t, out.String())
+}
+
+func TestEquationNumbering(t *testing.T) {
+	tom := New()
+	tom.EquationNumbering = true
+	assertGolden(t, tom, "testdata/equation.json", "testdata/equation.numbered.md")
+
+	// numbering starts over on every page
+	assertGolden(t, tom, "testdata/equation.json", "testdata/equation.numbered.md")
+}
+
+func TestTemplateBlockSkipped(t *testing.T) {
+	assertGolden(t, New(), "testdata/template.json", "testdata/template.page.md")
+
+	tom := New()
+	tom.EnableExtendedSyntax("hugo")
+	assertGolden(t, tom, "testdata/template.json", "testdata/template.page.md")
+}
+
+func TestColumnWidths(t *testing.T) {
+	assertGolden(t, New(), "testdata/columns.json", "testdata/columns.equal.md")
+
+	// the width_ratio of the testdata, go-notion doesn't decode it
+	ratios := map[string]float64{"column-1": 0.25, "column-2": 0.75}
+	tom := New()
+	tom.ColumnWidthRatio = func(columnID string) (float64, bool) {
+		ratio, ok := ratios[columnID]
+		return ratio, ok
+	}
+	assertGolden(t, tom, "testdata/columns.json", "testdata/columns.ratio.md")
+}
+
+func TestLinkedDatabaseRows(t *testing.T) {
+	blocks := []notion.Block{{ID: "db-1", Type: notion.BlockTypeChildDatabase, ChildDatabase: &notion.ChildDatabase{Title: "Reading List"}}}
+	pages := []DatabasePage{{ID: "page-1", Title: "Go Proverbs"}, {ID: "page-2", Title: "Draft ideas"}}
+	var queried []string
+	tom := New()
+	tom.QueryDatabasePages = func(databaseID string, limit int) ([]DatabasePage, error) {
+		queried = append(queried, fmt.Sprintf("%s:%d", databaseID, limit))
+		return pages, nil
+	}
+	tom.PageLinkResolver = func(pageID string) (string, bool) {
+		return "go-proverbs.md", pageID == "page-1"
+	}
+
+	// without LinkedDatabaseRows only the title is written
+	var out bytes.Buffer
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, "Reading List\n", out.String())
+	assert.Empty(t, queried)
+
+	tom.LinkedDatabaseRows = 5
+	out.Reset()
+	assert.NoError(t, tom.GenerateTo(blocks, &out))
+	assert.Equal(t, "Reading List\n\n- [Go Proverbs](go-proverbs.md)\n- Draft ideas\n", out.String())
+	assert.Equal(t, []string{"db-1:5"}, queried)
+}
+
+func TestGenerateToErrorNamesPage(t *testing.T) {
+	failure := errors.New("post-processing failed")
+	tom := New()
+	tom.PostProcess = func(content string) (string, error) {
+		return "", failure
+	}
+	tom.WithFrontMatter(notion.Page{
+		ID: "page-1",
+		Properties: notion.DatabasePageProperties{
+			"Name": {Type: notion.DBPropTypeTitle, Title: []notion.RichText{{Type: notion.RichTextTypeText, Text: &notion.Text{Content: "Hello World"}}}},
+		},
+	})
+
+	err := tom.GenerateTo(loadBlocks(t, "testdata/headings.json"), io.Discard)
+	assert.EqualError(t, err, `page "Hello World" (page-1): post-processing content: post-processing failed`)
+	assert.True(t, errors.Is(err, failure))
+}
+
+// TestNestedToggle renders the content of toggles inside <details>, nested
+// toggles included, between the blocks around them
+func TestNestedToggle(t *testing.T) {
+	assertGolden(t, New(), "testdata/toggle.json", "testdata/toggle.page.md")
+}
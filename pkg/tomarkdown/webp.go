@@ -0,0 +1,485 @@
+package tomarkdown
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// The WebP encoder writes lossless (VP8L) images: the subtract green
+// transform, LZ77 backward references and one set of prefix codes, see
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+const (
+	webpMaxSize      = 1 << 14
+	webpLengthCodes  = 24
+	webpDistCodes    = 40
+	webpHashBits     = 16
+	webpMinMatch     = 3
+	webpMaxMatch     = 4096
+	webpWindow       = 1 << 16
+	webpMaxChain     = 32
+	webpMaxCodeLen   = 15
+	webpMaxCLCodeLen = 7
+)
+
+// webpCodeLengthOrder is the order in which the code lengths of the code
+// length code are written
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// encodeWebP writes img as a lossless WebP image. A quality below 100 rounds
+// off the low bits of the color channels first, so the image compresses
+// better at the cost of small color changes: one bit from 70, two from 50
+// and three below.
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > webpMaxSize || height > webpMaxSize {
+		return errors.New("webp: image size out of range")
+	}
+
+	argb, alpha := webpPixels(img, webpNearLosslessBits(quality))
+	tokens := webpBackwardRefs(argb, width)
+
+	// one prefix code each for green (with the LZ77 lengths), red, blue, alpha
+	// and the distances
+	counts := [5][]int{
+		make([]int, 256+webpLengthCodes),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, 256),
+		make([]int, webpDistCodes),
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			counts[0][t.pixel>>8&0xff]++
+			counts[1][t.pixel>>16&0xff]++
+			counts[2][t.pixel&0xff]++
+			counts[3][t.pixel>>24]++
+			continue
+		}
+		code, _, _ := webpPrefix(t.length)
+		counts[0][256+code]++
+		code, _, _ = webpPrefix(t.dist)
+		counts[4][code]++
+	}
+
+	var bw webpBitWriter
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+	bw.write(1, 1) // transform: subtract green
+	bw.write(2, 2)
+	bw.write(0, 1) // no more transforms
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // no meta prefix codes
+
+	var codes [5]webpCode
+	for i := range codes {
+		codes[i] = newWebPCode(counts[i], webpMaxCodeLen)
+		bw.writeCode(codes[i])
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].write(&bw, int(t.pixel>>8&0xff))
+			codes[1].write(&bw, int(t.pixel>>16&0xff))
+			codes[2].write(&bw, int(t.pixel&0xff))
+			codes[3].write(&bw, int(t.pixel>>24))
+			continue
+		}
+		code, n, extra := webpPrefix(t.length)
+		codes[0].write(&bw, 256+code)
+		bw.write(uint32(extra), n)
+		code, n, extra = webpPrefix(t.dist)
+		codes[4].write(&bw, code)
+		bw.write(uint32(extra), n)
+	}
+
+	data := bw.bytes()
+	size := len(data)
+	if size%2 == 1 {
+		data = append(data, 0)
+	}
+	header := make([]byte, 20)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(size))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// webpNearLosslessBits returns how many low bits of each color channel are
+// rounded off for quality
+func webpNearLosslessBits(quality int) uint {
+	switch {
+	case quality >= 90:
+		return 0
+	case quality >= 70:
+		return 1
+	case quality >= 50:
+		return 2
+	}
+	return 3
+}
+
+// webpPixels returns the ARGB pixels of img with the green channel subtracted
+// from red and blue, and whether any pixel is transparent. dropBits low bits
+// of the color channels are rounded off.
+func webpPixels(img image.Image, dropBits uint) ([]uint32, bool) {
+	bounds := img.Bounds()
+	argb := make([]uint32, 0, bounds.Dx()*bounds.Dy())
+	alpha := false
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r, g, b := webpRound(c.R, dropBits), webpRound(c.G, dropBits), webpRound(c.B, dropBits)
+			if c.A != 0xff {
+				alpha = true
+			}
+			argb = append(argb, uint32(c.A)<<24|uint32(r-g)<<16|uint32(g)<<8|uint32(b-g))
+		}
+	}
+	return argb, alpha
+}
+
+// webpRound rounds v to a multiple of 1<<dropBits
+func webpRound(v uint8, dropBits uint) uint8 {
+	if dropBits == 0 {
+		return v
+	}
+	rounded := int(v) + 1<<(dropBits-1)
+	if rounded > 0xff {
+		rounded = 0xff
+	}
+	return uint8(rounded &^ (1<<dropBits - 1))
+}
+
+// webpToken is a literal pixel, or a copy of length pixels from dist pixels
+// back where dist is already the distance code value
+type webpToken struct {
+	pixel        uint32
+	length, dist int
+}
+
+// webpBackwardRefs splits the pixels into literals and copies of earlier
+// pixels, found through hash chains of three pixel sequences
+func webpBackwardRefs(argb []uint32, width int) []webpToken {
+	n := len(argb)
+	head := make([]int32, 1<<webpHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	hash := func(i int) uint32 {
+		h := argb[i]*0x1e35a7bd ^ argb[i+1]*0x9e3779b1 ^ argb[i+2]*0x85ebca6b
+		return h >> (32 - webpHashBits)
+	}
+	insert := func(i int) {
+		if i+webpMinMatch <= n {
+			h := hash(i)
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	tokens := make([]webpToken, 0, n/2)
+	for i := 0; i < n; {
+		best, bestDist := 0, 0
+		if i+webpMinMatch <= n {
+			limit := n - i
+			if limit > webpMaxMatch {
+				limit = webpMaxMatch
+			}
+			for j, chain := head[hash(i)], 0; j >= 0 && i-int(j) <= webpWindow && chain < webpMaxChain; j, chain = prev[j], chain+1 {
+				l := 0
+				for l < limit && argb[int(j)+l] == argb[i+l] {
+					l++
+				}
+				if l > best {
+					best, bestDist = l, i-int(j)
+					if l == limit {
+						break
+					}
+				}
+			}
+		}
+		if best < webpMinMatch {
+			tokens = append(tokens, webpToken{pixel: argb[i]})
+			insert(i)
+			i++
+			continue
+		}
+		tokens = append(tokens, webpToken{length: best, dist: webpDistanceCode(bestDist, width)})
+		for k := i; k < i+best; k++ {
+			insert(k)
+		}
+		i += best
+	}
+	return tokens
+}
+
+// webpDistanceCode returns the distance code value of a copy dist pixels
+// back: the pixel above and the one to the left have short codes, other
+// distances are offset by the 120 codes for nearby pixels.
+func webpDistanceCode(dist, width int) int {
+	switch dist {
+	case width:
+		return 1
+	case 1:
+		return 2
+	}
+	return dist + 120
+}
+
+// webpPrefix returns the prefix code of value (1 or more) with the number and
+// the value of its extra bits
+func webpPrefix(value int) (code int, extraBits uint, extra int) {
+	value--
+	if value < 4 {
+		return value, 0, 0
+	}
+	high := bits.Len(uint(value)) - 1
+	second := value >> (high - 1) & 1
+	extraBits = uint(high - 1)
+	return 2*high + second, extraBits, value & (1<<extraBits - 1)
+}
+
+// webpCode is a canonical prefix code: the code lengths of an alphabet and
+// the bit-reversed codes written for its symbols
+type webpCode struct {
+	lengths []uint8
+	codes   []uint16
+	used    []int
+}
+
+// newWebPCode builds a prefix code of at most maxLen bits for the symbol
+// counts. Lengths are limited by raising the smallest counts until the
+// Huffman tree is shallow enough, so the code stays complete.
+func newWebPCode(counts []int, maxLen int) webpCode {
+	c := webpCode{lengths: make([]uint8, len(counts)), codes: make([]uint16, len(counts))}
+	for symbol, count := range counts {
+		if count > 0 {
+			c.used = append(c.used, symbol)
+		}
+	}
+	if len(c.used) < 2 {
+		// a single symbol takes no bits
+		return c
+	}
+	for minCount := 1; ; minCount *= 2 {
+		weights := make([]int, len(c.used))
+		for i, symbol := range c.used {
+			weights[i] = counts[symbol]
+			if weights[i] < minCount {
+				weights[i] = minCount
+			}
+		}
+		depths := huffmanDepths(weights)
+		deepest := 0
+		for _, d := range depths {
+			if d > deepest {
+				deepest = d
+			}
+		}
+		if deepest <= maxLen {
+			for i, symbol := range c.used {
+				c.lengths[symbol] = uint8(depths[i])
+			}
+			break
+		}
+	}
+
+	// canonical codes, shorter codes first and then by symbol
+	var lengthCount [webpMaxCodeLen + 1]int
+	for _, l := range c.lengths {
+		lengthCount[l]++
+	}
+	lengthCount[0] = 0
+	var next [webpMaxCodeLen + 2]int
+	for l := 1; l <= webpMaxCodeLen; l++ {
+		next[l+1] = (next[l] + lengthCount[l]) << 1
+	}
+	for symbol, l := range c.lengths {
+		if l > 0 {
+			code := next[l]
+			next[l]++
+			c.codes[symbol] = uint16(bits.Reverse16(uint16(code)) >> (16 - l))
+		}
+	}
+	return c
+}
+
+// huffmanDepths returns the depth of every leaf of a Huffman tree with the
+// given weights, of which there are at least two
+func huffmanDepths(weights []int) []int {
+	type node struct {
+		weight int
+		parent int
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return weights[order[a]] < weights[order[b]] })
+
+	nodes := make([]node, len(weights), 2*len(weights)-1)
+	for i, w := range weights {
+		nodes[i] = node{weight: w, parent: -1}
+	}
+	// two queues: the sorted leaves and the internal nodes, which are created
+	// in increasing weight order
+	leaf, internal := 0, len(weights)
+	pick := func() int {
+		if leaf < len(order) && (internal >= len(nodes) || nodes[order[leaf]].weight <= nodes[internal].weight) {
+			leaf++
+			return order[leaf-1]
+		}
+		internal++
+		return internal - 1
+	}
+	for len(nodes) < 2*len(weights)-1 {
+		a, b := pick(), pick()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+
+	depths := make([]int, len(weights))
+	for i := range weights {
+		for p := nodes[i].parent; p >= 0; p = nodes[p].parent {
+			depths[i]++
+		}
+	}
+	return depths
+}
+
+// write writes the code of symbol
+func (c webpCode) write(bw *webpBitWriter, symbol int) {
+	bw.write(uint32(c.codes[symbol]), uint(c.lengths[symbol]))
+}
+
+// webpBitWriter packs bits starting with the least significant one
+type webpBitWriter struct {
+	buf   []byte
+	acc   uint64
+	nBits uint
+}
+
+func (bw *webpBitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nBits
+	bw.nBits += n
+	for bw.nBits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nBits -= 8
+	}
+}
+
+func (bw *webpBitWriter) bytes() []byte {
+	if bw.nBits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nBits = 0, 0
+	}
+	return bw.buf
+}
+
+// writeCode writes the code lengths of c, as a simple code for up to two
+// symbols below 256 and otherwise compressed with a code length code
+func (bw *webpBitWriter) writeCode(c webpCode) {
+	if len(c.used) <= 2 && (len(c.used) == 0 || c.used[len(c.used)-1] < 256) {
+		symbols := append([]int{}, c.used...)
+		if len(symbols) == 0 {
+			symbols = []int{0}
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(symbols)-1), 1)
+		if symbols[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbols[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbols[0]), 8)
+		}
+		if len(symbols) == 2 {
+			bw.write(uint32(symbols[1]), 8)
+		}
+		return
+	}
+
+	// code lengths 0-15, 17 and 18 for runs of 3-10 and 11-138 zeros
+	type clSymbol struct {
+		code, extra int
+	}
+	var seq []clSymbol
+	for i := 0; i < len(c.lengths); {
+		if c.lengths[i] != 0 {
+			seq = append(seq, clSymbol{code: int(c.lengths[i])})
+			i++
+			continue
+		}
+		run := 1
+		for i+run < len(c.lengths) && c.lengths[i+run] == 0 && run < 138 {
+			run++
+		}
+		switch {
+		case run >= 11:
+			seq = append(seq, clSymbol{code: 18, extra: run - 11})
+		case run >= 3:
+			seq = append(seq, clSymbol{code: 17, extra: run - 3})
+		default:
+			for k := 0; k < run; k++ {
+				seq = append(seq, clSymbol{})
+			}
+		}
+		i += run
+	}
+	clCounts := make([]int, 19)
+	for _, s := range seq {
+		clCounts[s.code]++
+	}
+	clCode := newWebPCode(clCounts, webpMaxCLCodeLen)
+	if len(clCode.used) == 1 {
+		// a lone symbol is still written with a length, the decoder reads it
+		// with no bits
+		clCode.lengths[clCode.used[0]] = 1
+	}
+
+	n := 4
+	for i, symbol := range webpCodeLengthOrder {
+		if clCode.lengths[symbol] > 0 && i+1 > n {
+			n = i + 1
+		}
+	}
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+	for _, symbol := range webpCodeLengthOrder[:n] {
+		bw.write(uint32(clCode.lengths[symbol]), 3)
+	}
+	bw.write(0, 1) // all symbols of the alphabet have a length
+	if len(clCode.used) == 1 {
+		clCode.lengths[clCode.used[0]] = 0
+	}
+	for _, s := range seq {
+		clCode.write(bw, s.code)
+		switch s.code {
+		case 17:
+			bw.write(uint32(s.extra), 3)
+		case 18:
+			bw.write(uint32(s.extra), 7)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package tomarkdown
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/dstotijn/go-notion"
+)
+
+// Supported values for ToMarkdown.InternalLinkStyle.
+const (
+	InternalLinkStyleMarkdown = "markdown"
+	InternalLinkStyleWikilink = "wikilink"
+)
+
+// wikilinks reports whether links to exported pages and downloaded images are
+// written as Obsidian wikilinks
+func (tm *ToMarkdown) wikilinks() bool {
+	return tm.InternalLinkStyle == InternalLinkStyleWikilink
+}
+
+// wikilink returns an Obsidian link to the exported page at link. Obsidian
+// finds pages by file name, so the title becomes the display text when the
+// file is named differently, e.g. [[getting-started|Getting Started]].
+func wikilink(title, link string) string {
+	target := linkedFileName(link)
+	if !strings.HasSuffix(link, "/") {
+		target = strings.TrimSuffix(target, path.Ext(target))
+	}
+	title = strings.Join(strings.Fields(title), " ")
+	if title == "" || title == target {
+		return "[[" + target + "]]"
+	}
+	return "[[" + target + "|" + wikilinkText.Replace(title) + "]]"
+}
+
+// imageEmbed returns the Obsidian embed of a downloaded image, e.g.
+// ![[photo.png|caption]], or an empty string when the image is linked at a
+// remote URL or wikilinks are not enabled.
+func (tm *ToMarkdown) imageEmbed(image *notion.FileBlock) string {
+	if !tm.wikilinks() {
+		return ""
+	}
+	var link string
+	switch {
+	case image.External != nil:
+		link = image.External.URL
+	case image.File != nil:
+		link = image.File.URL
+	}
+	if link == "" || strings.Contains(link, "://") || strings.HasPrefix(link, "data:") {
+		return ""
+	}
+	caption := strings.Join(strings.Fields(plainText(image.Caption)), " ")
+	if caption == "" {
+		return "![[" + linkedFileName(link) + "]]"
+	}
+	return "![[" + linkedFileName(link) + "|" + wikilinkText.Replace(caption) + "]]"
+}
+
+// linkedFileName returns the unescaped last path segment of a link
+func linkedFileName(link string) string {
+	name := path.Base(strings.TrimSuffix(link, "/"))
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		return unescaped
+	}
+	return name
+}
+
+// wikilinkText drops the characters that would end the text of a wikilink
+var wikilinkText = strings.NewReplacer("|", " ", "[", "", "]", "")